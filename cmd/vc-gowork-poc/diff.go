@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/pkgdiff"
+)
+
+// runDiff implements "diff <old> <new>". Like diff(1) it exits 0 when the
+// packages are identical, 1 when they differ and 2 on error.
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s diff <old archive|manifest.json> <new archive|manifest.json>\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	oldSnap, err := pkgdiff.Load(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+	newSnap, err := pkgdiff.Load(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+	result, err := pkgdiff.Compare(oldSnap, newSnap)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+
+	result.Print(os.Stdout)
+	if result.Empty() {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/depgraph"
+	"github.com/relaxnow/vc-gowork-poc/internal/selection"
+	"github.com/relaxnow/vc-gowork-poc/internal/util"
+)
+
+// runGraph implements "graph <directory>": it prints the local dependency
+// graph of every go.mod and go.work under the directory, or only the part
+// reachable from -module / -workspace matches. Exits 0 on success, 1 on
+// error and 2 on bad usage.
+func runGraph(args []string) int {
+	fs := flag.NewFlagSet("graph", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the graph as JSON")
+	var modulePatterns, workspacePatterns stringList
+	fs.Var(&modulePatterns, "module", "start from modules matching this module path or directory glob (repeatable)")
+	fs.Var(&workspacePatterns, "workspace", "start from go.work files in directories matching this glob (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s graph [flags] <directory>\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	root, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	var nodes []*depgraph.Node
+	if len(modulePatterns) > 0 || len(workspacePatterns) > 0 {
+		sel, err := selection.Resolve(root, modulePatterns, workspacePatterns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 2
+		}
+		nodes = sel.Closure
+	} else {
+		workFiles, modFiles, err := util.FindWorkAndModFiles(root)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		g := depgraph.New(nil)
+		for _, f := range append(workFiles, modFiles...) {
+			if err := g.Add(f); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				return 1
+			}
+		}
+		nodes = g.Sorted()
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(nodes, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(data))
+		return 0
+	}
+	printGraph(os.Stdout, root, nodes)
+	return 0
+}
+
+// printGraph writes one line per node and an indented line per edge, with
+// paths inside root shown relative to it.
+func printGraph(w io.Writer, root string, nodes []*depgraph.Node) {
+	show := func(p string) string {
+		if !util.IsWithin(p, root) {
+			return p
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return p
+		}
+		return filepath.ToSlash(rel)
+	}
+	for _, n := range nodes {
+		desc := n.Kind
+		if n.ModulePath != "" {
+			desc += " " + n.ModulePath
+		}
+		if !util.IsWithin(n.File, root) {
+			desc += ", outside tree"
+		}
+		if n.Missing {
+			desc += ", missing"
+		}
+		fmt.Fprintf(w, "%s [%s]\n", show(n.File), desc)
+		for _, e := range n.Edges {
+			if e.Directive == "use" {
+				fmt.Fprintf(w, "    use %s -> %s\n", e.Path, show(e.To))
+			} else {
+				fmt.Fprintf(w, "    replace %s => %s -> %s\n", e.Module, e.Path, show(e.To))
+			}
+		}
+	}
+	if len(nodes) == 0 {
+		fmt.Fprintf(w, "no go.mod or go.work found\n")
+	}
+}
@@ -1,11 +1,19 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 
 	"github.com/relaxnow/vc-gowork-poc/internal/copytree"
+	"github.com/relaxnow/vc-gowork-poc/internal/manifest"
+	"github.com/relaxnow/vc-gowork-poc/internal/report"
+	"github.com/relaxnow/vc-gowork-poc/internal/sbom"
+	"github.com/relaxnow/vc-gowork-poc/internal/selection"
 	"github.com/relaxnow/vc-gowork-poc/internal/util"
 	"github.com/relaxnow/vc-gowork-poc/internal/vendorstep"
 	"github.com/relaxnow/vc-gowork-poc/internal/workedit"
@@ -13,14 +21,103 @@ import (
 )
 
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <directory>\n", filepath.Base(os.Args[0]))
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "diff":
+			os.Exit(runDiff(os.Args[2:]))
+		case "graph":
+			os.Exit(runGraph(os.Args[2:]))
+		}
+	}
+
+	outPath := flag.String("o", "", "output archive path (default: <directory name>.<format ext> in the current directory)")
+	formatName := flag.String("format", "", "archive format: zip, tar.gz or tar.zst (default: from -o extension, else zip)")
+	manifestPath := flag.String("manifest", "", "write a JSON manifest of every packaged file to this path")
+	reportPath := flag.String("report", "", "write the packaging report as JSON to this path")
+	writeSBOM := flag.Bool("sbom", false, "write CycloneDX (.cdx.json) and SPDX (.spdx.json) SBOMs of the vendored modules next to the archive")
+	embedManifest := flag.Bool("embed-manifest", false, "also store the manifest as "+manifest.FileName+" in the archive root")
+	offline := flag.Bool("offline", false, "vendor without network access (GOPROXY=off, GOFLAGS=-mod=mod, go.sum as the only checksum source)")
+	proxyDir := flag.String("goproxy-dir", "", "resolve modules from this file:// GOPROXY directory instead of the network (implies -offline)")
+	moduleCache := flag.String("module-cache", "", "GOMODCACHE for the go commands run while vendoring")
+	strategyName := flag.String("vendor-strategy", string(vendorstep.StrategyAsIs), "as-is (vendor without tidy), tidy (go mod tidy first) or tidy-check (report go mod tidy -diff drift)")
+	jobs := flag.Int("jobs", runtime.NumCPU(), "number of workspaces/modules vendored in parallel")
+	sandboxKind := flag.String("sandbox", vendorstep.SandboxNone, "run go commands in a network-less sandbox: none, bwrap or unshare (implies -offline)")
+	toolchain := flag.String("gotoolchain", "local", "GOTOOLCHAIN policy for the go commands run while vendoring")
+	var goRoots stringList
+	flag.Var(&goRoots, "goroot", "GOROOT of a go toolchain to use when the go on PATH is older than the go.mod/go.work go lines (repeatable, tried in order)")
+	var goEnv envList
+	flag.Var(&goEnv, "go-env", "explicit KEY=VALUE for the go commands run while vendoring, e.g. GOPRIVATE=example.com (repeatable)")
+	replacePolicyName := flag.String("replace-policy", string(workedit.ReplaceKeep), "versioned replaces: keep, flag-forks (report replaces pointing at another module path) or localize (copy the target from the module cache)")
+	synthesizeWork := flag.Bool("synthesize-work", false, "if the directory has no go.work but several modules, generate a go.work using all of them and vendor them together")
+	alignReplaces := flag.Bool("align-replaces", false, "rewrite workspace member go.mod replaces overridden by go.work to the go.work target, so tidying a module alone matches the workspace")
+	var modulePatterns, workspacePatterns stringList
+	flag.Var(&modulePatterns, "module", "package only modules matching this module path or directory glob, plus their local replace dependencies (repeatable)")
+	flag.Var(&workspacePatterns, "workspace", "package only go.work files in directories matching this glob, plus their use and replace dependencies (repeatable)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <directory>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "       %s diff <old> <new>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "       %s graph [-json] <directory>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
 		os.Exit(2)
 	}
 
-	originalRoot, err := filepath.Abs(os.Args[1])
+	format, err := resolveFormat(*formatName, *outPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	strategy, err := vendorstep.ParseStrategy(*strategyName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	replacePolicy, err := workedit.ParseReplacePolicy(*replacePolicyName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	originalRoot, err := filepath.Abs(flag.Arg(0))
 	util.PanicOnErr(err)
 
+	// Restrict to selected modules/workspaces and their local dependencies
+	var sel *selection.Selection
+	if len(modulePatterns) > 0 || len(workspacePatterns) > 0 {
+		sel, err = selection.Resolve(originalRoot, modulePatterns, workspacePatterns)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		for _, u := range sel.Units {
+			why := "local dependency"
+			if u.Selected {
+				why = "selected"
+			}
+			fmt.Printf("[sel ] %s %s (%s)\n", u.Kind, u.Dir, why)
+		}
+	}
+
+	// The workspace the go command would use here: GOWORK, else the nearest
+	// go.work in this directory or above
+	gowork := os.Getenv("GOWORK")
+	for _, kv := range goEnv.stringList {
+		if k, v, _ := strings.Cut(kv, "="); k == "GOWORK" {
+			gowork = v
+		}
+	}
+	outerWork, err := workedit.FindWorkspace(originalRoot, gowork)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if util.IsWithin(outerWork, originalRoot) && filepath.Base(outerWork) == "go.work" {
+		outerWork = "" // found by the scan like any other
+	}
+
 	tempRoot, err := os.MkdirTemp("", "vc-gowork-poc-")
 	util.PanicOnErr(err)
 	// Comment the following to keep files on disk for debugging:
@@ -28,32 +125,298 @@ func main() {
 
 	// Copy source into temp workspace
 	copiedRoot := filepath.Join(tempRoot, filepath.Base(originalRoot))
-	util.PanicOnErr(copytree.CopyTreeNormalized(originalRoot, copiedRoot))
+	if sel != nil {
+		util.PanicOnErr(copytree.CopyTreeFiltered(originalRoot, copiedRoot, sel.Keep))
+	} else {
+		util.PanicOnErr(copytree.CopyTreeNormalized(originalRoot, copiedRoot))
+	}
 	fmt.Printf("[copy] %s -> %s\n", originalRoot, copiedRoot)
+	rep := report.New(originalRoot, copiedRoot)
+	externals := workedit.NewExternalCopies(originalRoot)
+
+	// Bring in a workspace from outside the tree as go.work at the root
+	if outerWork != "" {
+		usesTree, err := workedit.UsesWithin(outerWork, originalRoot)
+		util.PanicOnErr(err)
+		if _, err := os.Stat(filepath.Join(copiedRoot, "go.work")); err == nil {
+			rep.Addf("workspace", "go.work", "GOWORK=%s not used: the directory has its own go.work", outerWork)
+			fmt.Fprintf(os.Stderr, "warning: ignoring GOWORK=%s, %s has its own go.work\n", outerWork, originalRoot)
+		} else if !usesTree {
+			rep.Addf("workspace", "", "%s not used: it uses no module in the directory", outerWork)
+			fmt.Fprintf(os.Stderr, "warning: ignoring %s, it uses no module in %s\n", outerWork, originalRoot)
+		} else {
+			dest, err := externals.ImportWorkspace(outerWork, originalRoot, copiedRoot)
+			util.PanicOnErr(err)
+			fmt.Printf("[work] using %s as %s\n", outerWork, dest)
+		}
+	}
 
 	// Discover go.work and go.mod
 	workFiles, modFiles, err := util.FindWorkAndModFiles(copiedRoot)
 	util.PanicOnErr(err)
 	fmt.Printf("[scan] found %d go.work, %d go.mod\n", len(workFiles), len(modFiles))
 
+	// GOWORK=off: the go command ignores every go.work, so vendor each module alone
+	if gowork == "off" {
+		for _, w := range workFiles {
+			rep.Addf("workspace", w, "ignored: GOWORK=off, its modules are vendored on their own")
+			fmt.Printf("[work] GOWORK=off: ignoring %s\n", w)
+		}
+		workFiles = nil
+	}
+
+	// One workspace for a multi-module tree without go.work
+	if *synthesizeWork {
+		if gowork == "off" {
+			fmt.Printf("[work] not synthesizing go.work: GOWORK=off\n")
+		} else if len(workFiles) > 0 {
+			fmt.Printf("[work] not synthesizing go.work: %d go.work found\n", len(workFiles))
+		} else {
+			workPath, n, err := workedit.SynthesizeGoWork(copiedRoot, modFiles, rep)
+			util.PanicOnErr(err)
+			if workPath != "" {
+				workFiles = append(workFiles, workPath)
+				rep.Module(filepath.Dir(workPath), report.KindWorkspace).Synthesized = true
+				fmt.Printf("[work] synthesized %s using %d modules\n", workPath, n)
+			} else {
+				fmt.Printf("[work] not synthesizing go.work: %d module(s) to use\n", n)
+			}
+		}
+	}
+
+	// Options for the go commands
+	vendorOpts := vendorstep.Options{
+		Strategy:    strategy,
+		Offline:     *offline || *sandboxKind != vendorstep.SandboxNone,
+		Proxy:       *proxyDir,
+		ModuleCache: *moduleCache,
+		Toolchain:   *toolchain,
+		Jobs:        *jobs,
+		ExtraEnv:    goEnv.stringList,
+		Report:      rep,
+	}
+	vendorOpts.Runner, err = sandboxRunner(*sandboxKind, tempRoot, vendorOpts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		_ = os.RemoveAll(tempRoot)
+		os.Exit(2)
+	}
+	if dropped := vendorstep.DroppedGoFlags(); len(dropped) > 0 {
+		rep.Addf("env", "", "GOFLAGS entries ignored: %s", strings.Join(dropped, " "))
+		fmt.Fprintf(os.Stderr, "warning: ignoring GOFLAGS entries %q\n", dropped)
+	}
+
 	// Rewrite go.work and go.mod
 	externalBase := filepath.Join(copiedRoot, "_external")
 	util.PanicOnErr(os.MkdirAll(externalBase, 0o755))
 
-	usedModuleDirs, err := workedit.RewriteGoWorkFiles(originalRoot, copiedRoot, workFiles, externalBase)
+	workspaces, err := workedit.RewriteGoWorkFiles(originalRoot, copiedRoot, workFiles, externalBase, externals)
+	util.PanicOnErr(err)
+
+	util.PanicOnErr(workedit.RewriteGoModFiles(originalRoot, copiedRoot, modFiles, externalBase, externals))
+	util.PanicOnErr(workedit.RewriteExternalCopies(originalRoot, copiedRoot, externalBase, externals))
+
+	// Versioned replaces: keep, flag forks or localize from the module cache
+	_, externalMods, err := util.FindWorkAndModFiles(externalBase)
+	util.PanicOnErr(err)
+	var cacheDir string
+	if replacePolicy == workedit.ReplaceLocalize {
+		cacheDir, err = vendorOpts.GoEnvValue("GOMODCACHE")
+		util.PanicOnErr(err)
+	}
+	util.PanicOnErr(workedit.RewriteVersionedReplaces(workFiles, slices.Concat(modFiles, externalMods), replacePolicy, cacheDir, externalBase, externals, rep))
+	util.PanicOnErr(workedit.ResolveWorkspaceReplaces(workspaces, *alignReplaces, rep))
+	util.PanicOnErr(externals.WriteMapping(externalBase))
+
+	// Check the rewritten directives before go trips over them
+	util.PanicOnErr(workedit.ValidateRewrites(originalRoot, copiedRoot, workspaces, slices.Concat(modFiles, externalMods), externals, rep))
+
+	// Toolchain preflight: no go line may be newer than the go that runs
+	req, err := vendorstep.RequiredGo(workFiles, modFiles, workspaces)
 	util.PanicOnErr(err)
+	// Without network the go command cannot download a newer toolchain, so
+	// the check also applies when GOTOOLCHAIN would allow switching
+	if vendorOpts.Toolchain != "local" && !vendorOpts.Offline && vendorOpts.Proxy == "" {
+		fmt.Printf("[tool] GOTOOLCHAIN=%s: go %s required, toolchain selection left to the go command\n", vendorOpts.Toolchain, req.Go)
+	} else {
+		goRoot, goVersion, err := vendorOpts.SelectGoRoot(req, goRoots)
+		if err != nil {
+			rep.Addf("toolchain", "", "%v", err)
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			finishReport(rep, *reportPath)
+			_ = os.RemoveAll(tempRoot)
+			os.Exit(1)
+		}
+		vendorOpts.GoRoot = goRoot
+		rep.GoVersion = goVersion
+		if goRoot == "" {
+			fmt.Printf("[tool] %s from PATH (go %s required by %s)\n", goVersion, req.Go, rep.Rel(req.From))
+		} else {
+			fmt.Printf("[tool] %s from %s (go %s required by %s)\n", goVersion, goRoot, req.Go, rep.Rel(req.From))
+		}
+		if req.Toolchain != "" && !vendorstep.GoAtLeast(goVersion, req.Toolchain) {
+			rep.Addf("toolchain", "", "toolchain %s requested, vendoring with %s", req.Toolchain, goVersion)
+			fmt.Fprintf(os.Stderr, "warning: toolchain %s requested, vendoring with %s\n", req.Toolchain, goVersion)
+		}
+	}
 
-	util.PanicOnErr(workedit.RewriteGoModFiles(originalRoot, copiedRoot, modFiles, externalBase))
+	// Offline pre-check: everything go would download must already be local
+	if vendorOpts.Offline || vendorOpts.Proxy != "" {
+		missing, sourceDir, err := vendorstep.CheckModuleSource(workFiles, modFiles, workspaces, vendorOpts)
+		util.PanicOnErr(err)
+		var hard []vendorstep.MissingModule
+		for _, m := range missing {
+			if m.SumOnly {
+				rep.Addf("offline", m.NeededBy, "%s@%s%s missing from %s (only in go.sum, may not be needed)", m.Path, m.Version, m.Ext, sourceDir)
+				fmt.Fprintf(os.Stderr, "warning: %s@%s%s missing from %s, listed only in %s\n", m.Path, m.Version, m.Ext, sourceDir, rep.Rel(m.NeededBy))
+			} else {
+				hard = append(hard, m)
+			}
+		}
+		if len(hard) > 0 {
+			fmt.Fprintf(os.Stderr, "[offl] %d required module files missing from %s:\n", len(hard), sourceDir)
+			for _, m := range hard {
+				rep.Addf("offline", m.NeededBy, "%s@%s%s missing from %s", m.Path, m.Version, m.Ext, sourceDir)
+				fmt.Fprintf(os.Stderr, "  %s@%s%s (listed in %s)\n", m.Path, m.Version, m.Ext, rep.Rel(m.NeededBy))
+			}
+			finishReport(rep, *reportPath)
+			_ = os.RemoveAll(tempRoot)
+			os.Exit(1)
+		}
+		fmt.Printf("[offl] all required modules found in %s\n", sourceDir)
+	}
 
 	// Vendor
-	util.PanicOnErr(vendorstep.RunVendorSteps(workFiles, modFiles, usedModuleDirs))
+	util.PanicOnErr(vendorstep.RunVendorSteps(workFiles, modFiles, workspaces, vendorOpts))
+	util.PanicOnErr(vendorstep.ValidateVendoring(workFiles, modFiles, workspaces, rep))
 
-	// Zip with filter, include root folder
-	cwd, err := os.Getwd()
+	// Archive with filter, include root folder
+	outArchive := *outPath
+	if outArchive == "" {
+		cwd, err := os.Getwd()
+		util.PanicOnErr(err)
+		outArchive = filepath.Join(cwd, filepath.Base(copiedRoot)+format.Ext())
+	}
+	outArchive, err = filepath.Abs(outArchive)
 	util.PanicOnErr(err)
-	outZip := filepath.Join(cwd, filepath.Base(copiedRoot)+".zip")
-	fmt.Printf("[zip ] creating %s\n", outZip)
-	util.PanicOnErr(zipper.ZipDirFilteredIncludeRoot(copiedRoot, outZip))
 
+	// Manifest of packaged files, written before archiving so it can be embedded
+	var extra []zipper.ExtraFile
+	if *manifestPath != "" || *embedManifest {
+		m, err := manifest.Build(originalRoot, copiedRoot, externals.Origins())
+		util.PanicOnErr(err)
+		data, err := m.Marshal()
+		util.PanicOnErr(err)
+		if *manifestPath != "" {
+			util.PanicOnErr(os.WriteFile(*manifestPath, data, 0o644))
+			fmt.Printf("[mfst] wrote %s (%d entries)\n", *manifestPath, len(m.Entries))
+		}
+		if *embedManifest {
+			extra = append(extra, zipper.ExtraFile{Name: manifest.FileName, Data: data})
+		}
+	}
+
+	fmt.Printf("[zip ] creating %s (%s)\n", outArchive, format)
+	util.PanicOnErr(zipper.ArchiveDirFilteredIncludeRoot(copiedRoot, outArchive, format, extra...))
+
+	// SBOMs from vendor/modules.txt and go.sum
+	if *writeSBOM {
+		units, err := sbom.Collect(copiedRoot)
+		util.PanicOnErr(err)
+		sbomBase := zipper.TrimExt(outArchive)
+		name := filepath.Base(copiedRoot)
+
+		cdx, err := sbom.CycloneDX(name, units)
+		util.PanicOnErr(err)
+		util.PanicOnErr(os.WriteFile(sbomBase+".cdx.json", cdx, 0o644))
+		fmt.Printf("[sbom] wrote %s (%d vendored units)\n", sbomBase+".cdx.json", len(units))
+
+		spdx, err := sbom.SPDX(name, units)
+		util.PanicOnErr(err)
+		util.PanicOnErr(os.WriteFile(sbomBase+".spdx.json", spdx, 0o644))
+		fmt.Printf("[sbom] wrote %s\n", sbomBase+".spdx.json")
+	}
+
+	finishReport(rep, *reportPath)
 	fmt.Println("Packaging completed")
 }
+
+// sandboxRunner returns the runner for the go commands. In a sandbox only
+// the temp workspace and the go module and build caches are writable.
+func sandboxRunner(kind string, tempRoot string, opts vendorstep.Options) (vendorstep.Runner, error) {
+	if kind == vendorstep.SandboxNone {
+		return vendorstep.ExecRunner{}, nil
+	}
+	writable := []string{tempRoot}
+	for _, key := range []string{"GOMODCACHE", "GOCACHE"} {
+		dir, err := opts.GoEnvValue(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+		writable = append(writable, dir)
+	}
+	r, err := vendorstep.NewSandboxRunner(vendorstep.ExecRunner{}, kind, writable)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("[sbox] go commands run in %v\n", r)
+	return r, nil
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// envList is a repeatable KEY=VALUE flag.
+type envList struct{ stringList }
+
+func (l *envList) Set(s string) error {
+	if !strings.Contains(s, "=") {
+		return fmt.Errorf("want KEY=VALUE, got %q", s)
+	}
+	return l.stringList.Set(s)
+}
+
+// finishReport prints the issues collected in rep and writes it to path if set.
+func finishReport(rep *report.Report, path string) {
+	if n := rep.IssueCount(); n > 0 {
+		fmt.Fprintf(os.Stderr, "[rept] %d issues:\n", n)
+		rep.PrintIssues(os.Stderr)
+	}
+	if path != "" {
+		util.PanicOnErr(rep.WriteFile(path))
+		fmt.Printf("[rept] wrote %s\n", path)
+	}
+}
+
+// resolveFormat picks the archive format from -format, falling back to the
+// extension of -o and finally to zip. A -format that contradicts a known
+// extension of -o is an error, as diff reads the format from the name.
+func resolveFormat(formatName string, outPath string) (zipper.Format, error) {
+	if formatName != "" {
+		f, err := zipper.ParseFormat(formatName)
+		if err != nil {
+			return "", err
+		}
+		if named, ok := zipper.FormatFromName(outPath); ok && named != f {
+			return "", fmt.Errorf("-format %s does not match the %s extension of %q", f, named, outPath)
+		}
+		return f, nil
+	}
+	if outPath == "" {
+		return zipper.FormatZip, nil
+	}
+	if f, ok := zipper.FormatFromName(outPath); ok {
+		return f, nil
+	}
+	return "", fmt.Errorf("cannot infer archive format from %q; pass -format", outPath)
+}
@@ -4,4 +4,7 @@ go 1.23.0
 
 toolchain go1.24.6
 
-require golang.org/x/mod v0.27.0
+require (
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/mod v0.27.0
+)
@@ -16,6 +16,13 @@ import (
 // Otherwise it copies the dereferenced target (file or directory).
 // Never modifies original files. Panics if a source file cannot be read.
 func CopyTreeNormalized(srcRoot string, dstRoot string) error {
+	return CopyTreeFiltered(srcRoot, dstRoot, nil)
+}
+
+// CopyTreeFiltered is CopyTreeNormalized restricted to the entries keep
+// accepts. rel is slash separated and relative to srcRoot; rejecting a
+// directory skips everything below it. A nil keep copies everything.
+func CopyTreeFiltered(srcRoot string, dstRoot string, keep func(rel string, isDir bool) bool) error {
 	srcInfo, err := os.Lstat(srcRoot)
 	if err != nil {
 		return err
@@ -38,6 +45,12 @@ func CopyTreeNormalized(srcRoot string, dstRoot string) error {
 		if relFromSrcRoot == "." {
 			return nil
 		}
+		if keep != nil && !keep(filepath.ToSlash(relFromSrcRoot), entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		currentDstPath := filepath.Join(dstRoot, relFromSrcRoot)
 
 		switch {
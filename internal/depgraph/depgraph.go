@@ -0,0 +1,182 @@
+// Package depgraph resolves the local dependency graph of go.mod and go.work
+// files: go.work use directives and directory replace directives, followed
+// recursively wherever they lead, inside the tree or not.
+package depgraph
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/report"
+	"github.com/relaxnow/vc-gowork-poc/internal/util"
+
+	"golang.org/x/mod/modfile"
+)
+
+// Node is one go.mod or go.work file.
+type Node struct {
+	File       string `json:"file"`                 // absolute path of the go.mod or go.work
+	Kind       string `json:"kind"`                 // report.KindModule or report.KindWorkspace
+	ModulePath string `json:"modulePath,omitempty"` // module directive of a go.mod
+	Missing    bool   `json:"missing,omitempty"`    // referenced but not on disk
+	Edges      []Edge `json:"edges,omitempty"`
+}
+
+// Dir returns the directory of the node's file.
+func (n *Node) Dir() string { return filepath.Dir(n.File) }
+
+// Edge is one use or directory replace directive.
+type Edge struct {
+	Directive string `json:"directive"`        // "use" or "replace"
+	Module    string `json:"module,omitempty"` // replaced module path
+	Path      string `json:"path"`             // directory as written
+	Line      int    `json:"line"`
+	To        string `json:"to"` // File of the target node
+}
+
+// Graph is the set of nodes reachable from the files added to it.
+type Graph struct {
+	Nodes map[string]*Node // keyed by File
+
+	origins map[string]string // copied dir -> original dir
+}
+
+// New returns an empty graph. origins maps directories copied from
+// elsewhere (see workedit.ExternalCopies) to where they came from; relative
+// paths in files under such a copy are resolved from the original location,
+// since they were written for it. origins may be nil.
+func New(origins map[string]string) *Graph {
+	return &Graph{Nodes: make(map[string]*Node), origins: origins}
+}
+
+// Add adds the go.mod or go.work file and everything reachable from it.
+func (g *Graph) Add(file string) error {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	if _, ok := g.Nodes[file]; ok {
+		return nil
+	}
+	n := &Node{File: file, Kind: report.KindModule}
+	if filepath.Base(file) == "go.work" {
+		n.Kind = report.KindWorkspace
+	}
+	g.Nodes[file] = n
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		n.Missing = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	base := g.baseDir(n.Dir())
+	if n.Kind == report.KindWorkspace {
+		wf, err := modfile.ParseWork(file, data, nil)
+		if err != nil {
+			return err
+		}
+		for _, u := range wf.Use {
+			n.Edges = append(n.Edges, Edge{Directive: "use", Path: u.Path, Line: u.Syntax.Start.Line,
+				To: filepath.Join(localDir(base, u.Path), "go.mod")})
+		}
+		for _, r := range wf.Replace {
+			if r.New.Version == "" {
+				n.Edges = append(n.Edges, Edge{Directive: "replace", Module: r.Old.Path, Path: r.New.Path, Line: r.Syntax.Start.Line,
+					To: filepath.Join(localDir(base, r.New.Path), "go.mod")})
+			}
+		}
+	} else {
+		mf, err := modfile.Parse(file, data, nil)
+		if err != nil {
+			return err
+		}
+		if mf.Module != nil {
+			n.ModulePath = mf.Module.Mod.Path
+		}
+		for _, r := range mf.Replace {
+			if r.New.Version == "" {
+				n.Edges = append(n.Edges, Edge{Directive: "replace", Module: r.Old.Path, Path: r.New.Path, Line: r.Syntax.Start.Line,
+					To: filepath.Join(localDir(base, r.New.Path), "go.mod")})
+			}
+		}
+	}
+
+	for _, e := range n.Edges {
+		if err := g.Add(e.To); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Closure returns the nodes reachable from files (included), sorted by File.
+// Files not in the graph are ignored.
+func (g *Graph) Closure(files ...string) []*Node {
+	seen := make(map[string]bool)
+	var walk func(file string)
+	walk = func(file string) {
+		n, ok := g.Nodes[file]
+		if !ok || seen[file] {
+			return
+		}
+		seen[file] = true
+		for _, e := range n.Edges {
+			walk(e.To)
+		}
+	}
+	for _, f := range files {
+		walk(f)
+	}
+	nodes := make([]*Node, 0, len(seen))
+	for f := range seen {
+		nodes = append(nodes, g.Nodes[f])
+	}
+	sortNodes(nodes)
+	return nodes
+}
+
+// Sorted returns every node, sorted by File.
+func (g *Graph) Sorted() []*Node {
+	nodes := make([]*Node, 0, len(g.Nodes))
+	for _, n := range g.Nodes {
+		nodes = append(nodes, n)
+	}
+	sortNodes(nodes)
+	return nodes
+}
+
+// baseDir returns the directory relative paths in dir's files resolve
+// against: dir itself, or its original location if dir lies in a copy.
+func (g *Graph) baseDir(dir string) string {
+	for copied, orig := range g.origins {
+		if !util.IsWithin(dir, copied) {
+			continue
+		}
+		rel, err := filepath.Rel(copied, dir)
+		if err != nil {
+			continue
+		}
+		return filepath.Join(orig, rel)
+	}
+	return dir
+}
+
+// localDir resolves a use or replace path relative to base.
+func localDir(base string, p string) string {
+	p = filepath.FromSlash(p)
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p)
+	}
+	return filepath.Join(base, p)
+}
+
+func sortNodes(nodes []*Node) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].File < nodes[j].File })
+}
@@ -0,0 +1,239 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/util"
+	"github.com/relaxnow/vc-gowork-poc/internal/zipper"
+)
+
+// FileName is the name of the manifest when embedded in an archive.
+const FileName = "MANIFEST.json"
+
+// Reason says why a file is in the package.
+type Reason string
+
+const (
+	ReasonSource    Reason = "source"    // copied from the scanned tree
+	ReasonExternal  Reason = "external"  // copied into _external from outside the tree
+	ReasonVendored  Reason = "vendored"  // written by go mod vendor / go work vendor
+	ReasonGenerated Reason = "generated" // created during packaging, no original (e.g. a new go.sum)
+)
+
+// Entry describes one packaged file.
+type Entry struct {
+	Name        string `json:"name"`                  // archive entry name, including the root folder
+	Original    string `json:"original,omitempty"`    // absolute path of the original file
+	OriginalRel string `json:"originalRel,omitempty"` // original path relative to the scanned root
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256,omitempty"`
+	Symlink     string `json:"symlink,omitempty"`  // link target, for symlinks kept inside the tree
+	Modified    bool   `json:"modified,omitempty"` // content differs from the original (rewritten go.mod/go.work, tidied go.sum)
+	Reason      Reason `json:"reason"`
+	Content     string `json:"content,omitempty"` // text of the file, if KeepsContent
+}
+
+// Manifest lists every file in a package.
+type Manifest struct {
+	Root    string  `json:"root"` // absolute path of the scanned directory
+	Entries []Entry `json:"entries"`
+}
+
+// Build walks copiedRoot with the same filter as the archiver and describes
+// each file. externals maps copied _external dirs to their original dirs.
+func Build(originalRoot string, copiedRoot string, externals map[string]string) (*Manifest, error) {
+	m := &Manifest{Root: originalRoot}
+	rootName := filepath.Base(copiedRoot)
+	vendored := vendorDirs{root: copiedRoot, known: make(map[string]bool)}
+
+	err := filepath.WalkDir(copiedRoot, func(currentPath string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(copiedRoot, currentPath)
+		if err != nil {
+			return err
+		}
+		if !zipper.Allow(rel) {
+			return nil
+		}
+
+		e := Entry{Name: filepath.ToSlash(filepath.Join(rootName, rel))}
+		if entry.Type()&fs.ModeSymlink != 0 {
+			target, err := os.Readlink(currentPath)
+			if err != nil {
+				return err
+			}
+			e.Symlink = target
+			e.Size = int64(len(target))
+		} else {
+			size, sum, err := hashFile(currentPath)
+			if err != nil {
+				return err
+			}
+			e.Size, e.SHA256 = size, sum
+			if KeepsContent(rel) {
+				data, err := os.ReadFile(currentPath)
+				if err != nil {
+					return err
+				}
+				e.Content = string(data)
+			}
+		}
+
+		if vendored.contains(rel) {
+			e.Reason = ReasonVendored
+		} else {
+			if origDir, copyDir, ok := externalOrigin(currentPath, externals); ok {
+				within, err := filepath.Rel(copyDir, currentPath)
+				if err != nil {
+					return err
+				}
+				e.Reason = ReasonExternal
+				e.Original = filepath.Join(origDir, within)
+			} else {
+				e.Reason = ReasonSource
+				e.Original = filepath.Join(originalRoot, rel)
+				e.OriginalRel = filepath.ToSlash(rel)
+			}
+			if err := compareOriginal(&e); err != nil {
+				return err
+			}
+		}
+
+		m.Entries = append(m.Entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(m.Entries, func(i, j int) bool { return m.Entries[i].Name < m.Entries[j].Name })
+	return m, nil
+}
+
+// Marshal renders the manifest as indented JSON.
+func (m *Manifest) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// ReadFile loads a manifest written by Marshal.
+func ReadFile(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// KeepsContent reports whether the manifest stores the text of the file
+// rel: the go.work, go.mod and modules.txt files the diff command compares
+// line by line and module by module.
+func KeepsContent(rel string) bool {
+	switch filepath.Base(rel) {
+	case "go.work", "go.mod", "modules.txt":
+		return true
+	}
+	return false
+}
+
+/* ---------- helpers ---------- */
+
+// compareOriginal marks e as modified if its content differs from the
+// original, or as generated if there is no original.
+func compareOriginal(e *Entry) error {
+	if e.Symlink != "" {
+		return nil
+	}
+	_, origSum, err := hashFile(e.Original)
+	if errors.Is(err, fs.ErrNotExist) {
+		e.Reason = ReasonGenerated
+		e.Original, e.OriginalRel = "", ""
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	e.Modified = origSum != e.SHA256
+	return nil
+}
+
+// vendorDirs recognizes the vendor directories written by go mod vendor and
+// go work vendor: a "vendor" directly inside a directory with a go.mod or
+// go.work. A package that is merely named vendor is source.
+type vendorDirs struct {
+	root  string
+	known map[string]bool // slash dir relative to root -> is a vendor dir
+}
+
+// contains reports whether the file rel lies in a vendor directory.
+func (v vendorDirs) contains(rel string) bool {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i, part := range parts[:len(parts)-1] {
+		if part == "vendor" && v.isVendorDir(strings.Join(parts[:i+1], "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v vendorDirs) isVendorDir(dir string) bool {
+	if is, ok := v.known[dir]; ok {
+		return is
+	}
+	parent := filepath.Join(v.root, filepath.FromSlash(path.Dir(dir)))
+	is := false
+	for _, name := range []string{"go.mod", "go.work"} {
+		if _, err := os.Stat(filepath.Join(parent, name)); err == nil {
+			is = true
+		}
+	}
+	v.known[dir] = is
+	return is
+}
+
+// externalOrigin finds the external copy containing p, preferring the
+// deepest one.
+func externalOrigin(p string, externals map[string]string) (origDir string, copyDir string, ok bool) {
+	for dest, orig := range externals {
+		if util.IsWithin(p, dest) && len(dest) > len(copyDir) {
+			origDir, copyDir, ok = orig, dest, true
+		}
+	}
+	return
+}
+
+func hashFile(p string) (int64, string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,117 @@
+package modulestxt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Module is one "# path version" block of vendor/modules.txt.
+type Module struct {
+	Path        string
+	Version     string // empty for workspace members and versionless replaces
+	Replacement string // "path" or "path version" after "=>", if replaced
+	Explicit    bool   // "## explicit" marker: required directly by a go.mod
+	GoVersion   string // from "## explicit; go 1.x"
+	Packages    []string
+}
+
+// ID returns "path@version", or just path when there is no version.
+func (m Module) ID() string {
+	if m.Version == "" {
+		return m.Path
+	}
+	return m.Path + "@" + m.Version
+}
+
+// File is a parsed vendor/modules.txt.
+type File struct {
+	Workspace bool // "## workspace" header written by go work vendor
+	Modules   []Module
+}
+
+// ParseFile reads and parses the modules.txt at path.
+func ParseFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Parse parses modules.txt content as written by go mod vendor or
+// go work vendor.
+func Parse(data []byte) (*File, error) {
+	f := &File{}
+	var cur *Module
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "## "):
+			annotations := strings.TrimPrefix(line, "## ")
+			if cur == nil {
+				if annotations == "workspace" {
+					f.Workspace = true
+				}
+				continue
+			}
+			for _, a := range strings.Split(annotations, ";") {
+				a = strings.TrimSpace(a)
+				switch {
+				case a == "explicit":
+					cur.Explicit = true
+				case strings.HasPrefix(a, "go "):
+					cur.GoVersion = strings.TrimPrefix(a, "go ")
+				}
+			}
+		case strings.HasPrefix(line, "# "):
+			m, err := parseHeader(strings.TrimPrefix(line, "# "))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			f.Modules = append(f.Modules, m)
+			cur = &f.Modules[len(f.Modules)-1]
+		default:
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: package %q before any module", lineNo, line)
+			}
+			cur.Packages = append(cur.Packages, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// parseHeader parses "path [version] [=> path [version]]".
+func parseHeader(s string) (Module, error) {
+	var m Module
+	lhs, rhs, replaced := strings.Cut(s, "=>")
+	fields := strings.Fields(lhs)
+	switch len(fields) {
+	case 1:
+		m.Path = fields[0]
+	case 2:
+		m.Path, m.Version = fields[0], fields[1]
+	default:
+		return m, fmt.Errorf("malformed module line %q", s)
+	}
+	if replaced {
+		m.Replacement = strings.Join(strings.Fields(rhs), " ")
+		if m.Replacement == "" {
+			return m, fmt.Errorf("malformed module line %q", s)
+		}
+	}
+	return m, nil
+}
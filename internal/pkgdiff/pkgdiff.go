@@ -0,0 +1,323 @@
+package pkgdiff
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/manifest"
+	"github.com/relaxnow/vc-gowork-poc/internal/modulestxt"
+	"github.com/relaxnow/vc-gowork-poc/internal/zipper"
+)
+
+// File is one packaged file. Content is only kept for go.work, go.mod and
+// modules.txt (see manifest.KeepsContent); manifests written before they
+// stored it have none.
+type File struct {
+	Size    int64
+	SHA256  string
+	Content []byte
+}
+
+// Snapshot is the set of files in one package, keyed by name relative to
+// the archive root folder so packages of differently named roots compare.
+type Snapshot struct {
+	Source string
+	Files  map[string]File
+}
+
+// Load reads an archive (zip, tar.gz, tar.zst) or a manifest (.json).
+// An embedded MANIFEST.json is ignored.
+func Load(p string) (*Snapshot, error) {
+	s := &Snapshot{Source: p, Files: make(map[string]File)}
+
+	if strings.EqualFold(path.Ext(p), ".json") {
+		m, err := manifest.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range m.Entries {
+			sum := e.SHA256
+			if e.Symlink != "" {
+				sum = hashBytes([]byte(e.Symlink))
+			}
+			f := File{Size: e.Size, SHA256: sum}
+			if e.Content != "" {
+				f.Content = []byte(e.Content)
+			}
+			s.Files[stripRoot(e.Name)] = f
+		}
+		return s, nil
+	}
+
+	err := zipper.WalkArchive(p, func(name string, r io.Reader) error {
+		rel := stripRoot(name)
+		if rel == manifest.FileName {
+			return nil
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		f := File{Size: int64(len(data)), SHA256: hashBytes(data)}
+		if manifest.KeepsContent(rel) {
+			f.Content = data
+		}
+		s.Files[rel] = f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// TextChange is a line diff of a go.work or go.mod file present in both.
+type TextChange struct {
+	Name    string
+	Removed []string
+	Added   []string
+}
+
+// ModuleChange is a vendored module whose version differs in a modules.txt.
+// An empty Old or New means the module was added or removed.
+type ModuleChange struct {
+	File string // modules.txt name
+	Path string
+	Old  string
+	New  string
+}
+
+// Result lists the differences between two snapshots.
+type Result struct {
+	Added         []string
+	Removed       []string
+	Modified      []string
+	ConfigChanges []TextChange
+	ModuleChanges []ModuleChange
+	// NoContent lists modified go.work, go.mod and modules.txt files that
+	// could not be compared line by line: a manifest from an older version
+	// has only their hashes.
+	NoContent []string
+}
+
+// Empty reports whether the snapshots were identical.
+func (r *Result) Empty() bool {
+	return len(r.Added) == 0 && len(r.Removed) == 0 && len(r.Modified) == 0
+}
+
+// Compare reports what changed going from a to b.
+func Compare(a, b *Snapshot) (*Result, error) {
+	r := &Result{}
+	for name, fa := range a.Files {
+		fb, ok := b.Files[name]
+		switch {
+		case !ok:
+			r.Removed = append(r.Removed, name)
+		case fa.SHA256 != fb.SHA256:
+			r.Modified = append(r.Modified, name)
+		}
+	}
+	for name := range b.Files {
+		if _, ok := a.Files[name]; !ok {
+			r.Added = append(r.Added, name)
+		}
+	}
+	sort.Strings(r.Added)
+	sort.Strings(r.Removed)
+	sort.Strings(r.Modified)
+
+	for _, name := range r.Modified {
+		fa, fb := a.Files[name], b.Files[name]
+		if !manifest.KeepsContent(name) {
+			continue
+		}
+		if fa.Content == nil || fb.Content == nil {
+			r.NoContent = append(r.NoContent, name)
+			continue
+		}
+		switch path.Base(name) {
+		case "go.work", "go.mod":
+			removed, added := diffLines(splitLines(fa.Content), splitLines(fb.Content))
+			r.ConfigChanges = append(r.ConfigChanges, TextChange{Name: name, Removed: removed, Added: added})
+		case "modules.txt":
+			changes, err := diffModules(name, fa.Content, fb.Content)
+			if err != nil {
+				return nil, err
+			}
+			r.ModuleChanges = append(r.ModuleChanges, changes...)
+		}
+	}
+	// Vendored modules appearing or disappearing with a whole modules.txt
+	for _, name := range r.Added {
+		if f := b.Files[name]; f.Content != nil && path.Base(name) == "modules.txt" {
+			changes, err := diffModules(name, nil, f.Content)
+			if err != nil {
+				return nil, err
+			}
+			r.ModuleChanges = append(r.ModuleChanges, changes...)
+		}
+	}
+	for _, name := range r.Removed {
+		if f := a.Files[name]; f.Content != nil && path.Base(name) == "modules.txt" {
+			changes, err := diffModules(name, f.Content, nil)
+			if err != nil {
+				return nil, err
+			}
+			r.ModuleChanges = append(r.ModuleChanges, changes...)
+		}
+	}
+	return r, nil
+}
+
+// Print writes a human-readable report of r.
+func (r *Result) Print(w io.Writer) {
+	if r.Empty() {
+		fmt.Fprintln(w, "packages are identical")
+		return
+	}
+	for _, name := range r.Added {
+		fmt.Fprintf(w, "A %s\n", name)
+	}
+	for _, name := range r.Removed {
+		fmt.Fprintf(w, "D %s\n", name)
+	}
+	for _, name := range r.Modified {
+		fmt.Fprintf(w, "M %s\n", name)
+	}
+	for _, c := range r.ConfigChanges {
+		fmt.Fprintf(w, "\n--- %s\n", c.Name)
+		for _, l := range c.Removed {
+			fmt.Fprintf(w, "- %s\n", l)
+		}
+		for _, l := range c.Added {
+			fmt.Fprintf(w, "+ %s\n", l)
+		}
+	}
+	if len(r.NoContent) > 0 {
+		fmt.Fprintf(w, "\nnot compared, a manifest has no content for them (regenerate it or compare the archives):\n")
+		for _, name := range r.NoContent {
+			fmt.Fprintf(w, "  %s\n", name)
+		}
+	}
+	if len(r.ModuleChanges) > 0 {
+		fmt.Fprintln(w, "\nvendored modules:")
+		for _, c := range r.ModuleChanges {
+			switch {
+			case c.Old == "":
+				fmt.Fprintf(w, "  + %s %s (%s)\n", c.Path, c.New, c.File)
+			case c.New == "":
+				fmt.Fprintf(w, "  - %s %s (%s)\n", c.Path, c.Old, c.File)
+			default:
+				fmt.Fprintf(w, "  ~ %s %s -> %s (%s)\n", c.Path, c.Old, c.New, c.File)
+			}
+		}
+	}
+	fmt.Fprintf(w, "\n%d added, %d removed, %d modified\n", len(r.Added), len(r.Removed), len(r.Modified))
+}
+
+/* ---------- helpers ---------- */
+
+func stripRoot(name string) string {
+	if _, rest, ok := strings.Cut(name, "/"); ok {
+		return rest
+	}
+	return name
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func splitLines(data []byte) []string {
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+}
+
+// diffLines returns the lines only in a and only in b, based on a longest
+// common subsequence. go.mod and go.work files are small enough for O(n*m).
+func diffLines(a, b []string) (removed, added []string) {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			removed = append(removed, a[i])
+			i++
+		default:
+			added = append(added, b[j])
+			j++
+		}
+	}
+	removed = append(removed, a[i:]...)
+	added = append(added, b[j:]...)
+	return removed, added
+}
+
+// diffModules compares the module versions listed in two modules.txt files.
+// A nil side counts as an empty file.
+func diffModules(name string, a, b []byte) ([]ModuleChange, error) {
+	va, err := moduleVersions(name, a)
+	if err != nil {
+		return nil, err
+	}
+	vb, err := moduleVersions(name, b)
+	if err != nil {
+		return nil, err
+	}
+	var changes []ModuleChange
+	for p, oldV := range va {
+		if newV := vb[p]; newV != oldV {
+			changes = append(changes, ModuleChange{File: name, Path: p, Old: oldV, New: newV})
+		}
+	}
+	for p, newV := range vb {
+		if _, ok := va[p]; !ok {
+			changes = append(changes, ModuleChange{File: name, Path: p, New: newV})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// moduleVersions maps module path to "version [=> replacement]".
+func moduleVersions(name string, data []byte) (map[string]string, error) {
+	out := make(map[string]string)
+	if data == nil {
+		return out, nil
+	}
+	f, err := modulestxt.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	for _, m := range f.Modules {
+		v := m.Version
+		if m.Replacement != "" {
+			v = strings.TrimSpace(v + " => " + m.Replacement)
+		}
+		if v == "" {
+			v = "(local)"
+		}
+		out[m.Path] = v
+	}
+	return out, nil
+}
@@ -0,0 +1,193 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/util"
+)
+
+// Report collects what happened to each workspace and module during a
+// packaging run, for review after the fact.
+type Report struct {
+	Root        string    `json:"root"`                  // absolute path of the scanned directory
+	GoVersion   string    `json:"goVersion,omitempty"`   // version of the go command run
+	Environment []string  `json:"environment,omitempty"` // GO* variables of the go commands run
+	Modules     []*Module `json:"modules,omitempty"`
+	Issues      []Issue   `json:"issues,omitempty"` // findings not tied to one module
+
+	copiedRoot string
+	mu         sync.Mutex // guards Modules, Issues and each Module's Issues and Commands
+}
+
+// Module is the part of the report for one go.work or go.mod directory.
+type Module struct {
+	Dir         string    `json:"dir"`                   // relative to the package root, slash separated
+	Kind        string    `json:"kind"`                  // KindWorkspace or KindModule
+	GOWORK      string    `json:"gowork,omitempty"`      // GOWORK pinned for go work commands, relative to the package root
+	TidyDiff    string    `json:"tidyDiff,omitempty"`    // output of go mod tidy -diff when not tidy
+	Synthesized bool      `json:"synthesized,omitempty"` // go.work generated by -synthesize-work
+	Replaces    []Replace `json:"replaces,omitempty"`    // replaces pointing at a module version
+	// Effective lists the replaces in force for a workspace after go.work
+	// overrides member go.mod replaces.
+	Effective []Replacement `json:"effectiveReplaces,omitempty"`
+	Commands  []Command     `json:"commands,omitempty"`
+	Issues    []Issue       `json:"issues,omitempty"`
+
+	r *Report
+}
+
+// Command records one child command run for a module.
+type Command struct {
+	Args       []string `json:"args"`
+	Dir        string   `json:"dir"` // relative to the package root
+	ExitCode   int      `json:"exitCode"`
+	DurationMS int64    `json:"durationMs"`
+	Output     string   `json:"output,omitempty"` // combined stdout and stderr
+}
+
+// Replace records a replace directive whose target is a module version
+// rather than a directory, and what was done with it.
+type Replace struct {
+	File   string `json:"file"` // go.mod or go.work
+	Line   int    `json:"line"`
+	Old    string `json:"old"`             // path, or path@version
+	New    string `json:"new"`             // path@version as written
+	Fork   bool   `json:"fork,omitempty"`  // New is a different module path
+	Action string `json:"action"`          // kept, flagged or localized
+	Local  string `json:"local,omitempty"` // copy the replace now points at, relative to the package root
+}
+
+// Replacement is one replace in force for a workspace.
+type Replacement struct {
+	Old  string `json:"old"`  // path, or path@version
+	New  string `json:"new"`  // directory relative to the package root, or path@version
+	From string `json:"from"` // go.work or member go.mod declaring it, relative to the package root
+}
+
+const (
+	KindWorkspace = "workspace"
+	KindModule    = "module"
+)
+
+// Issue is one problem found by a stage.
+type Issue struct {
+	Stage   string `json:"stage"`
+	File    string `json:"file,omitempty"`
+	Message string `json:"message"`
+}
+
+// New returns an empty report for originalRoot packaged from copiedRoot.
+func New(originalRoot string, copiedRoot string) *Report {
+	return &Report{Root: originalRoot, copiedRoot: copiedRoot}
+}
+
+// Module returns the entry for the directory dir inside the copied tree,
+// creating it on first use. Safe for concurrent use.
+func (r *Report) Module(dir string, kind string) *Module {
+	rel := r.Rel(dir)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.Modules {
+		if m.Dir == rel && m.Kind == kind {
+			return m
+		}
+	}
+	m := &Module{Dir: rel, Kind: kind, r: r}
+	r.Modules = append(r.Modules, m)
+	return m
+}
+
+// Rel returns p relative to the package root, slash separated. Paths outside
+// the copied tree are returned unchanged.
+func (r *Report) Rel(p string) string {
+	if !util.IsWithin(p, r.copiedRoot) {
+		return p
+	}
+	rel, err := filepath.Rel(r.copiedRoot, p)
+	if err != nil {
+		return p
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Addf records an issue not tied to a module.
+func (r *Report) Addf(stage string, file string, format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Issues = append(r.Issues, Issue{Stage: stage, File: r.Rel(file), Message: fmt.Sprintf(format, args...)})
+}
+
+// Addf records an issue for m. file is relative to the module directory and
+// may be empty.
+func (m *Module) Addf(stage string, file string, format string, args ...any) {
+	m.r.mu.Lock()
+	defer m.r.mu.Unlock()
+	m.Issues = append(m.Issues, Issue{Stage: stage, File: file, Message: fmt.Sprintf(format, args...)})
+}
+
+// AddReplace records a versioned replace in m.
+func (m *Module) AddReplace(r Replace) {
+	m.r.mu.Lock()
+	defer m.r.mu.Unlock()
+	m.Replaces = append(m.Replaces, r)
+}
+
+// SetEffectiveReplaces records the replaces in force for workspace m.
+func (m *Module) SetEffectiveReplaces(rs []Replacement) {
+	m.r.mu.Lock()
+	defer m.r.mu.Unlock()
+	m.Effective = rs
+}
+
+// AddCommand records a command run for m.
+func (m *Module) AddCommand(c Command) {
+	m.r.mu.Lock()
+	defer m.r.mu.Unlock()
+	m.Commands = append(m.Commands, c)
+}
+
+// IssueCount returns the total number of issues recorded.
+func (r *Report) IssueCount() int {
+	n := len(r.Issues)
+	for _, m := range r.Modules {
+		n += len(m.Issues)
+	}
+	return n
+}
+
+// PrintIssues writes every issue, one per line.
+func (r *Report) PrintIssues(w io.Writer) {
+	for _, is := range r.Issues {
+		if is.File == "" {
+			fmt.Fprintf(w, "  %s: %s\n", is.Stage, is.Message)
+		} else {
+			fmt.Fprintf(w, "  %s: %s: %s\n", is.Stage, is.File, is.Message)
+		}
+	}
+	for _, m := range r.Modules {
+		for _, is := range m.Issues {
+			where := m.Dir
+			if is.File != "" {
+				where = path.Join(m.Dir, is.File)
+			}
+			fmt.Fprintf(w, "  %s: %s: %s\n", is.Stage, where, is.Message)
+		}
+	}
+}
+
+// WriteFile writes the report as indented JSON, modules sorted by directory.
+func (r *Report) WriteFile(path string) error {
+	sort.SliceStable(r.Modules, func(i, j int) bool { return r.Modules[i].Dir < r.Modules[j].Dir })
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,183 @@
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/modulestxt"
+
+	"golang.org/x/mod/modfile"
+)
+
+// Module is one third-party module shipped in a vendor directory.
+type Module struct {
+	Path    string
+	Version string
+	Sum     string // go.sum "h1:" hash of the module zip, if known
+}
+
+// Unit is a workspace or standalone module that was vendored.
+type Unit struct {
+	Name    string // module path, or "workspace:<dir>" for a go.work
+	Dir     string // relative to the package root, slash separated
+	Modules []Module
+}
+
+// Collect finds every vendor/modules.txt under root that belongs to a
+// go.work or go.mod and lists the modules it vendors. Modules replaced by a
+// local directory are skipped: their code is already part of the package.
+// Module-path replacements are reported as the replacement module, once per
+// unit; replacement records without packages are not modules in vendor/.
+func Collect(root string) ([]Unit, error) {
+	var units []Unit
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if !d.IsDir() || d.Name() != "vendor" {
+			return nil
+		}
+		unitDir := filepath.Dir(p)
+		modulesTxt := filepath.Join(p, "modules.txt")
+		if !fileExists(modulesTxt) {
+			return filepath.SkipDir
+		}
+		unit, err := collectUnit(root, unitDir, modulesTxt)
+		if err != nil {
+			return err
+		}
+		if unit != nil {
+			units = append(units, *unit)
+		}
+		return filepath.SkipDir
+	})
+	return units, err
+}
+
+func collectUnit(root string, unitDir string, modulesTxt string) (*Unit, error) {
+	rel, err := filepath.Rel(root, unitDir)
+	if err != nil {
+		return nil, err
+	}
+	unit := &Unit{Dir: filepath.ToSlash(rel)}
+
+	sumFiles := []string{filepath.Join(unitDir, "go.sum")}
+	workPath := filepath.Join(unitDir, "go.work")
+	modPath := filepath.Join(unitDir, "go.mod")
+	switch {
+	case fileExists(workPath):
+		unit.Name = "workspace:" + unit.Dir
+		sumFiles = append(sumFiles, filepath.Join(unitDir, "go.work.sum"))
+		uses, err := workUses(workPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range uses {
+			sumFiles = append(sumFiles, filepath.Join(u, "go.sum"))
+		}
+	case fileExists(modPath):
+		data, err := os.ReadFile(modPath)
+		if err != nil {
+			return nil, err
+		}
+		unit.Name = modfile.ModulePath(data)
+	default:
+		return nil, nil
+	}
+
+	sums, err := readSums(sumFiles)
+	if err != nil {
+		return nil, err
+	}
+	mt, err := modulestxt.ParseFile(modulesTxt)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, m := range mt.Modules {
+		if m.Version == "" && len(m.Packages) == 0 {
+			// A trailing "# x => y v1.2.0" record: a wildcard or unused
+			// replacement, not a module shipped in vendor/
+			continue
+		}
+		path, version := m.Path, m.Version
+		if m.Replacement != "" {
+			fields := strings.Fields(m.Replacement)
+			if len(fields) != 2 {
+				continue // replaced by a local directory
+			}
+			path, version = fields[0], fields[1]
+		}
+		if version == "" {
+			continue // workspace member
+		}
+		if seen[path+"@"+version] {
+			continue
+		}
+		seen[path+"@"+version] = true
+		unit.Modules = append(unit.Modules, Module{
+			Path:    path,
+			Version: version,
+			Sum:     sums[path+" "+version],
+		})
+	}
+	return unit, nil
+}
+
+// workUses returns the absolute directories listed in go.work use directives.
+func workUses(workPath string) ([]string, error) {
+	data, err := os.ReadFile(workPath)
+	if err != nil {
+		return nil, err
+	}
+	wf, err := modfile.ParseWork(workPath, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, u := range wf.Use {
+		dir := u.Path
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(workPath), dir)
+		}
+		dirs = append(dirs, filepath.Clean(dir))
+	}
+	return dirs, nil
+}
+
+// readSums maps "path version" to the module zip hash from go.sum files.
+// Missing files are ignored.
+func readSums(files []string) (map[string]string, error) {
+	sums := make(map[string]string)
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			fields := strings.Fields(sc.Text())
+			if len(fields) != 3 || strings.HasSuffix(fields[1], "/go.mod") {
+				continue
+			}
+			sums[fields[0]+" "+fields[1]] = fields[2]
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return sums, nil
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
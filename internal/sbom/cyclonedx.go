@@ -0,0 +1,141 @@
+package sbom
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ToolName identifies this tool in generated documents.
+const ToolName = "vc-gowork-poc"
+
+type cdxBOM struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	SerialNumber string          `json:"serialNumber"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	BOMRef     string        `json:"bom-ref,omitempty"`
+	Type       string        `json:"type"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	PURL       string        `json:"purl,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// CycloneDX renders units as a CycloneDX 1.5 JSON document. Each unit is an
+// application component depending on the modules it vendors.
+func CycloneDX(name string, units []Unit) ([]byte, error) {
+	serial, err := uuid()
+	if err != nil {
+		return nil, err
+	}
+	bom := cdxBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + serial,
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Tools:     cdxTools{Components: []cdxComponent{{Type: "application", Name: ToolName}}},
+			Component: cdxComponent{BOMRef: "package:" + name, Type: "application", Name: name},
+		},
+		Components: []cdxComponent{},
+	}
+
+	rootDep := cdxDependency{Ref: bom.Metadata.Component.BOMRef, DependsOn: []string{}}
+	seen := make(map[string]bool)
+	for _, u := range units {
+		unitRef := "unit:" + u.Dir
+		rootDep.DependsOn = append(rootDep.DependsOn, unitRef)
+		bom.Components = append(bom.Components, cdxComponent{
+			BOMRef:     unitRef,
+			Type:       "application",
+			Name:       u.Name,
+			Properties: []cdxProperty{{Name: ToolName + ":dir", Value: u.Dir}},
+		})
+		unitDep := cdxDependency{Ref: unitRef, DependsOn: []string{}}
+		for _, m := range u.Modules {
+			purl := PURL(m.Path, m.Version)
+			unitDep.DependsOn = append(unitDep.DependsOn, purl)
+			if seen[purl] {
+				continue
+			}
+			seen[purl] = true
+			c := cdxComponent{BOMRef: purl, Type: "library", Name: m.Path, Version: m.Version, PURL: purl}
+			if m.Sum != "" {
+				c.Properties = append(c.Properties, cdxProperty{Name: ToolName + ":gosum", Value: m.Sum})
+			}
+			bom.Components = append(bom.Components, c)
+		}
+		bom.Dependencies = append(bom.Dependencies, unitDep)
+	}
+	bom.Dependencies = append([]cdxDependency{rootDep}, bom.Dependencies...)
+	purls := make([]string, 0, len(seen))
+	for purl := range seen {
+		purls = append(purls, purl)
+	}
+	sort.Strings(purls)
+	for _, purl := range purls {
+		bom.Dependencies = append(bom.Dependencies, cdxDependency{Ref: purl, DependsOn: []string{}})
+	}
+
+	return marshal(bom)
+}
+
+// PURL returns the package URL of a Go module version.
+func PURL(path string, version string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return "pkg:golang/" + strings.Join(parts, "/") + "@" + url.PathEscape(version)
+}
+
+func marshal(v any) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// uuid returns a random RFC 4122 version 4 UUID.
+func uuid() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
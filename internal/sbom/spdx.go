@@ -0,0 +1,112 @@
+package sbom
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID           string            `json:"SPDXID"`
+	Name             string            `json:"name"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	Comment          string            `json:"comment,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+var spdxIDUnsafe = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// SPDX renders units as an SPDX 2.3 JSON document.
+func SPDX(name string, units []Unit) ([]byte, error) {
+	id, err := uuid()
+	if err != nil {
+		return nil, err
+	}
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: fmt.Sprintf("https://github.com/relaxnow/vc-gowork-poc/spdx/%s-%s", spdxIDUnsafe.ReplaceAllString(name, "-"), id),
+		CreationInfo: spdxCreationInfo{
+			Created:  time.Now().UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: " + ToolName},
+		},
+	}
+
+	rootID := "SPDXRef-Package-" + spdxIDUnsafe.ReplaceAllString(name, "-")
+	doc.Packages = append(doc.Packages, noAssertionPackage(rootID, name, ""))
+	doc.Relationships = append(doc.Relationships, spdxRelationship{doc.SPDXID, "DESCRIBES", rootID})
+
+	seen := make(map[string]string) // purl -> SPDXID
+	for i, u := range units {
+		unitID := fmt.Sprintf("SPDXRef-Unit-%d", i)
+		unitPkg := noAssertionPackage(unitID, u.Name, "")
+		unitPkg.Comment = "vendored at " + u.Dir
+		doc.Packages = append(doc.Packages, unitPkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{rootID, "CONTAINS", unitID})
+
+		for _, m := range u.Modules {
+			purl := PURL(m.Path, m.Version)
+			modID, ok := seen[purl]
+			if !ok {
+				modID = fmt.Sprintf("SPDXRef-Module-%d", len(seen))
+				seen[purl] = modID
+				pkg := noAssertionPackage(modID, m.Path, m.Version)
+				pkg.ExternalRefs = []spdxExternalRef{{"PACKAGE-MANAGER", "purl", purl}}
+				if m.Sum != "" {
+					pkg.Comment = "go.sum " + m.Sum
+				}
+				doc.Packages = append(doc.Packages, pkg)
+			}
+			doc.Relationships = append(doc.Relationships, spdxRelationship{unitID, "DEPENDS_ON", modID})
+		}
+	}
+
+	return marshal(doc)
+}
+
+func noAssertionPackage(id string, name string, version string) spdxPackage {
+	return spdxPackage{
+		SPDXID:           id,
+		Name:             name,
+		VersionInfo:      version,
+		DownloadLocation: "NOASSERTION",
+		LicenseConcluded: "NOASSERTION",
+		LicenseDeclared:  "NOASSERTION",
+		CopyrightText:    "NOASSERTION",
+	}
+}
@@ -0,0 +1,211 @@
+// Package selection restricts packaging to chosen modules and workspaces
+// and the local directories they depend on.
+package selection
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/depgraph"
+	"github.com/relaxnow/vc-gowork-poc/internal/report"
+	"github.com/relaxnow/vc-gowork-poc/internal/util"
+
+	"golang.org/x/mod/modfile"
+)
+
+// Unit is one module or workspace kept in the package.
+type Unit struct {
+	Dir      string // relative to the root, slash separated
+	Kind     string // report.KindWorkspace or report.KindModule
+	Selected bool   // matched a pattern; false for local dependencies
+}
+
+// Selection is the part of a tree to package.
+type Selection struct {
+	Root    string
+	Units   []Unit // inside Root, sorted by Dir, then Kind
+	Graph   *depgraph.Graph
+	Closure []*depgraph.Node // everything reachable from the matches, including outside Root
+
+	modDirs  map[string]bool // every module directory in the tree
+	keepMods map[string]bool
+	keepWork map[string]bool
+}
+
+// Resolve matches modulePatterns against the module path and directory of
+// every go.mod under root, and workspacePatterns against the directory of
+// every go.work. Patterns use path.Match syntax; directories are relative to
+// root and slash separated ("." is root itself). Each match is extended with
+// its closure in the local dependency graph (see depgraph). A pattern matching nothing is an error.
+func Resolve(root string, modulePatterns []string, workspacePatterns []string) (*Selection, error) {
+	workFiles, modFiles, err := util.FindWorkAndModFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	s := &Selection{
+		Root:     root,
+		modDirs:  make(map[string]bool),
+		keepMods: make(map[string]bool),
+		keepWork: make(map[string]bool),
+	}
+
+	modPaths := make(map[string]string) // dir -> module path
+	for _, modFile := range modFiles {
+		dir := s.rel(filepath.Dir(modFile))
+		s.modDirs[dir] = true
+		if data, err := os.ReadFile(modFile); err == nil {
+			modPaths[dir] = modfile.ModulePath(data)
+		}
+	}
+
+	selectedMods := make(map[string]bool)
+	for _, pat := range modulePatterns {
+		pat = cleanPattern(pat)
+		found := false
+		for dir := range s.modDirs {
+			ok, err := matchAny(pat, dir, modPaths[dir])
+			if err != nil {
+				return nil, fmt.Errorf("-module %q: %w", pat, err)
+			}
+			if ok {
+				selectedMods[dir] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("-module %q matches no module under %s", pat, root)
+		}
+	}
+
+	selectedWork := make(map[string]bool)
+	for _, pat := range workspacePatterns {
+		pat = strings.TrimSuffix(cleanPattern(pat), "/go.work")
+		found := false
+		for _, workFile := range workFiles {
+			dir := s.rel(filepath.Dir(workFile))
+			ok, err := matchAny(pat, dir)
+			if err != nil {
+				return nil, fmt.Errorf("-workspace %q: %w", pat, err)
+			}
+			if ok {
+				selectedWork[dir] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("-workspace %q matches no go.work under %s", pat, root)
+		}
+	}
+
+	var from []string
+	for dir := range selectedWork {
+		from = append(from, filepath.Join(root, filepath.FromSlash(dir), "go.work"))
+	}
+	for dir := range selectedMods {
+		from = append(from, filepath.Join(root, filepath.FromSlash(dir), "go.mod"))
+	}
+	s.Graph = depgraph.New(nil)
+	for _, f := range from {
+		if err := s.Graph.Add(f); err != nil {
+			return nil, err
+		}
+	}
+	s.Closure = s.Graph.Closure(from...)
+
+	// Only what lies inside root is copied; the rewrite step copies the
+	// rest under _external.
+	for _, n := range s.Closure {
+		if !util.IsWithin(n.File, root) {
+			continue
+		}
+		dir := s.rel(n.Dir())
+		if n.Kind == report.KindWorkspace {
+			s.keepWork[dir] = true
+			s.Units = append(s.Units, Unit{Dir: dir, Kind: n.Kind, Selected: selectedWork[dir]})
+		} else {
+			s.modDirs[dir] = true
+			s.keepMods[dir] = true
+			s.Units = append(s.Units, Unit{Dir: dir, Kind: n.Kind, Selected: selectedMods[dir]})
+		}
+	}
+	sort.Slice(s.Units, func(i, j int) bool {
+		if s.Units[i].Dir != s.Units[j].Dir {
+			return s.Units[i].Dir < s.Units[j].Dir
+		}
+		return s.Units[i].Kind > s.Units[j].Kind
+	})
+	return s, nil
+}
+
+// Keep reports whether the entry rel (relative to Root, slash separated) is
+// copied. It has the signature copytree.CopyTreeFiltered expects.
+//   - go.work and go.work.sum only in selected workspace directories
+//   - other files if the nearest enclosing module is kept
+//   - directories on the way to a kept unit or inside a kept module
+func (s *Selection) Keep(rel string, isDir bool) bool {
+	if isDir {
+		if s.keepMods[s.owner(rel)] {
+			return true
+		}
+		for _, u := range s.Units {
+			if u.Dir == rel || strings.HasPrefix(u.Dir, rel+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	dir := path.Dir(rel)
+	switch path.Base(rel) {
+	case "go.work", "go.work.sum":
+		return s.keepWork[dir]
+	}
+	return s.keepMods[s.owner(dir)]
+}
+
+// owner returns the nearest module directory containing dir, or "" if
+// there is none.
+func (s *Selection) owner(dir string) string {
+	for {
+		if s.modDirs[dir] {
+			return dir
+		}
+		if dir == "." {
+			return ""
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+func (s *Selection) rel(p string) string {
+	rel, err := filepath.Rel(s.Root, p)
+	if err != nil {
+		return p
+	}
+	return filepath.ToSlash(rel)
+}
+
+func cleanPattern(pat string) string {
+	pat = strings.TrimSuffix(filepath.ToSlash(pat), "/")
+	if pat == "" {
+		return "."
+	}
+	return strings.TrimPrefix(pat, "./")
+}
+
+// matchAny reports whether pat matches any of the non-empty names.
+func matchAny(pat string, names ...string) (bool, error) {
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		ok, err := path.Match(pat, name)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
@@ -1,11 +1,9 @@
 package util
 
 import (
-	"errors"
 	"io/fs"
 	"os"
 	"path/filepath"
-	"strconv"
 )
 
 func PanicOnErr(err error) {
@@ -64,19 +62,6 @@ func IsUnderAny(dir string, set map[string]struct{}) bool {
 	return false
 }
 
-func UniqueDir(base string) string {
-	tryPath := base
-	index := 1
-	for {
-		_, err := os.Stat(tryPath)
-		if errors.Is(err, os.ErrNotExist) {
-			return tryPath
-		}
-		tryPath = base + "-" + strconv.Itoa(index)
-		index++
-	}
-}
-
 func hasDotDotPrefix(rel string) bool {
 	return len(rel) >= 2 && rel[:2] == ".."
 }
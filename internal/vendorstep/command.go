@@ -0,0 +1,67 @@
+package vendorstep
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/report"
+)
+
+// failureTailLines is how much of a failed command's output is printed.
+const failureTailLines = 20
+
+// execCmd runs a command in dir through opts.Runner and records it on rm.
+// Output is captured rather than streamed; when the command fails, the tail
+// of its combined output is written to w.
+func execCmd(w io.Writer, opts Options, rm *report.Module, env []string, dir string, args ...string) Result {
+	start := time.Now()
+	res := opts.runner().Run(Cmd{Dir: dir, Env: env, Args: args})
+	rec := report.Command{
+		Args:       args,
+		Dir:        rm.Dir,
+		ExitCode:   res.ExitCode,
+		DurationMS: time.Since(start).Milliseconds(),
+		Output:     res.Combined,
+	}
+	rm.AddCommand(rec)
+
+	if res.Err != nil && rec.Output != "" {
+		fmt.Fprintf(w, "  $ %s (exit %d, %dms)\n", strings.Join(rec.Args, " "), rec.ExitCode, rec.DurationMS)
+		for _, line := range tail(rec.Output, failureTailLines) {
+			fmt.Fprintf(w, "  | %s\n", line)
+		}
+	}
+	return res
+}
+
+// tail returns the last n lines of s.
+func tail(s string, n int) []string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// lockedBuffer is a bytes.Buffer safe for the concurrent writes exec makes
+// when Stdout and Stderr are different writers.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
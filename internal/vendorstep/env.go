@@ -0,0 +1,165 @@
+package vendorstep
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// inheritedGoVars are the go settings passed through from our own
+// environment. The other goEnvVars (GOFLAGS, GOWORK, GOPRIVATE, GOTOOLCHAIN,
+// GOENV, ...) are dropped and set explicitly.
+var inheritedGoVars = map[string]bool{
+	"GOPATH":     true,
+	"GOROOT":     true,
+	"GOCACHE":    true,
+	"GOMODCACHE": true,
+	"GOPROXY":    true,
+	"GOSUMDB":    true,
+	"GOTMPDIR":   true,
+}
+
+// goEnvVars are the variables the go command reads as settings: the keys
+// "go env" reports, plus the per-architecture ones it lists only for the
+// current GOARCH. They are cleared from the inherited environment before
+// baseGoEnv sets its own; other variables starting with GO, such as
+// GOOGLE_APPLICATION_CREDENTIALS for a credential helper, pass through.
+var goEnvVars = map[string]bool{
+	"GO111MODULE": true, "GOARCH": true, "GOAUTH": true, "GOBIN": true,
+	"GOCACHE": true, "GOCACHEPROG": true, "GODEBUG": true, "GOENV": true,
+	"GOEXE": true, "GOEXPERIMENT": true, "GOFIPS140": true, "GOFLAGS": true,
+	"GOGCCFLAGS": true, "GOHOSTARCH": true, "GOHOSTOS": true, "GOINSECURE": true,
+	"GOMOD": true, "GOMODCACHE": true, "GONOPROXY": true, "GONOSUMDB": true,
+	"GOOS": true, "GOPATH": true, "GOPRIVATE": true, "GOPROXY": true,
+	"GOROOT": true, "GOSUMDB": true, "GOTELEMETRY": true, "GOTELEMETRYDIR": true,
+	"GOTMPDIR": true, "GOTOOLCHAIN": true, "GOTOOLDIR": true, "GOVCS": true,
+	"GOVERSION": true, "GOWORK": true,
+	"GO386": true, "GOAMD64": true, "GOARM": true, "GOARM64": true,
+	"GOMIPS": true, "GOMIPS64": true, "GOPPC64": true, "GORISCV64": true,
+	"GOWASM": true,
+}
+
+// allowedGoFlags are the GOFLAGS entries kept from our environment. Flags
+// such as -mod=vendor or -modfile would change what gets vendored.
+var allowedGoFlags = map[string]bool{
+	"-modcacherw":     true,
+	"-mod=mod":        true,
+	"-trimpath":       true,
+	"-buildvcs=false": true,
+	"-buildvcs=true":  true,
+	"-buildvcs=auto":  true,
+}
+
+// DroppedGoFlags returns the entries of our GOFLAGS not on the allow-list.
+func DroppedGoFlags() []string {
+	_, dropped := filterGoFlags(os.Getenv("GOFLAGS"))
+	return dropped
+}
+
+func filterGoFlags(goflags string) (kept []string, dropped []string) {
+	for _, f := range strings.Fields(goflags) {
+		if allowedGoFlags[f] {
+			kept = append(kept, f)
+		} else {
+			dropped = append(dropped, f)
+		}
+	}
+	return kept, dropped
+}
+
+// baseGoEnv returns the GO* settings shared by every go command, before
+// GOWORK is pinned.
+func (o Options) baseGoEnv() map[string]string {
+	vars := make(map[string]string)
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if inheritedGoVars[k] {
+			vars[k] = v
+		}
+	}
+
+	goflags, _ := filterGoFlags(os.Getenv("GOFLAGS"))
+	vars["GOENV"] = "off" // ignore settings made with go env -w
+	vars["GO111MODULE"] = "on"
+	vars["GOTOOLCHAIN"] = o.toolchain()
+
+	if o.GoRoot != "" {
+		vars["GOROOT"] = o.GoRoot
+	}
+	if o.ModuleCache != "" {
+		vars["GOMODCACHE"] = o.ModuleCache
+	}
+	switch {
+	case o.Proxy != "":
+		vars["GOPROXY"] = proxyURL(o.Proxy)
+	case o.Offline:
+		vars["GOPROXY"] = "off"
+	}
+	if o.Offline || o.Proxy != "" {
+		vars["GOSUMDB"] = "off"
+		if !slices.Contains(goflags, "-mod=mod") {
+			goflags = append(goflags, "-mod=mod")
+		}
+	}
+	vars["GOFLAGS"] = strings.Join(goflags, " ")
+
+	for _, kv := range o.ExtraEnv {
+		k, v, _ := strings.Cut(kv, "=")
+		vars[k] = v
+	}
+	return vars
+}
+
+// commandEnv returns the full environment for one go command. GOWORK is the
+// rewritten go.work for workspace commands and off for everything else.
+func (o Options) commandEnv(workFile string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		k, _, _ := strings.Cut(kv, "=")
+		if !goEnvVars[k] {
+			env = append(env, kv)
+		}
+	}
+	vars := o.baseGoEnv()
+	vars["GOWORK"] = "off"
+	if workFile != "" {
+		vars["GOWORK"] = workFile
+	}
+	return append(env, sortedEnv(vars)...)
+}
+
+// EffectiveEnv returns the GO* variables shared by every go command, sorted.
+// GOWORK is pinned per command and not included.
+func (o Options) EffectiveEnv() []string {
+	return sortedEnv(o.baseGoEnv())
+}
+
+func (o Options) toolchain() string {
+	if o.Toolchain == "" {
+		return "local"
+	}
+	return o.Toolchain
+}
+
+// proxyURL turns a proxy directory into a file:// URL.
+func proxyURL(p string) string {
+	if strings.HasPrefix(p, "file://") {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		abs = p
+	}
+	return "file://" + filepath.ToSlash(abs)
+}
+
+func sortedEnv(vars map[string]string) []string {
+	env := make([]string, 0, len(vars))
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
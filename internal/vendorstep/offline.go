@@ -0,0 +1,245 @@
+package vendorstep
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/workedit"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// MissingModule is a module file the go command would have to download.
+type MissingModule struct {
+	Path     string
+	Version  string
+	Ext      string // ".mod" or ".zip"
+	NeededBy string // go.mod, go.sum or go.work.sum listing the version
+	// SumOnly is set for a version listed only in a go.sum or go.work.sum.
+	// Such lines also cover go.mod files outside the pruned module graph,
+	// test-only dependencies and stale entries, none of which go mod vendor
+	// fetches, so they are not proof that packaging will fail.
+	SumOnly bool
+}
+
+// ModuleSourceDir returns the directory the pre-check looks in: the file://
+// proxy directory if configured, otherwise the module download cache.
+func (o Options) ModuleSourceDir() (string, error) {
+	if o.Proxy != "" {
+		return strings.TrimPrefix(o.Proxy, "file://"), nil
+	}
+	cache, err := o.GoEnvValue("GOMODCACHE")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "cache", "download"), nil
+}
+
+// GoEnvValue returns what "go env key" reports in the environment the vendor
+// stage gives its go commands, run through opts.Runner like them.
+func (o Options) GoEnvValue(key string) (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	res := o.runner().Run(Cmd{Dir: dir, Env: o.commandEnv(""), Args: []string{o.goCommand(), "env", key}})
+	if res.Err != nil {
+		return "", fmt.Errorf("go env %s: %w", key, res.Err)
+	}
+	return strings.TrimSpace(res.Stdout), nil
+}
+
+// CheckModuleSource lists the module versions named by go.mod requires and
+// go.sum / go.work.sum lines that are not available in the proxy directory or
+// module cache, and returns the directory it checked. Requires are resolved
+// through the go.work replaces of each workspace using the module, then its
+// own replaces; those replaced by a local directory are not needed. Required versions, including the go.sum
+// lines for them, are hard requirements; versions seen only in a sum file
+// are marked SumOnly.
+func CheckModuleSource(workFiles []string, modFiles []string, workspaces workedit.Workspaces, opts Options) ([]MissingModule, string, error) {
+	sourceDir, err := opts.ModuleSourceDir()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Each go.mod is resolved once per context the vendor stage runs it in:
+	// alone, and as a member of every workspace using it. In a workspace the go.work
+	// replaces come first, keyed by module path as the go command does.
+	contexts := make(map[string][]map[string][]*modfile.Replace) // go.mod -> go.work replaces, nil alone
+	var modPaths []string
+	addContext := func(modPath string, workReplaces map[string][]*modfile.Replace) {
+		modPath = filepath.Clean(modPath)
+		if _, ok := contexts[modPath]; !ok {
+			modPaths = append(modPaths, modPath)
+		}
+		contexts[modPath] = append(contexts[modPath], workReplaces)
+	}
+	// Members are only resolved alone when the strategy tidies them
+	memberDirs := workspaces.AllModuleDirs()
+	for _, m := range modFiles {
+		if _, member := memberDirs[filepath.Dir(filepath.Clean(m))]; !member || opts.Strategy == StrategyTidy || opts.Strategy == StrategyTidyCheck {
+			addContext(m, nil)
+		}
+	}
+	var sumPaths []string
+	for _, w := range workFiles {
+		sumPaths = append(sumPaths, filepath.Join(filepath.Dir(w), "go.work.sum"))
+	}
+	workPaths := make([]string, 0, len(workspaces))
+	for w := range workspaces {
+		workPaths = append(workPaths, w)
+	}
+	sort.Strings(workPaths)
+	for _, w := range workPaths {
+		data, err := os.ReadFile(w)
+		if err != nil {
+			return nil, "", err
+		}
+		wf, err := modfile.ParseWork(w, data, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		workReplaces := replacesByPath(wf.Replace)
+		for _, d := range workspaces.ModuleDirs(w) {
+			addContext(filepath.Join(d, "go.mod"), workReplaces)
+		}
+	}
+
+	type need struct{ path, version, ext string }
+	needed := make(map[need]string)
+	required := make(map[string]bool) // path@version named by a go.mod require
+	for _, modPath := range modPaths {
+		data, err := os.ReadFile(modPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		mf, err := modfile.Parse(modPath, data, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		modReplaces := replacesByPath(mf.Replace)
+		for _, workReplaces := range contexts[modPath] {
+			for _, r := range mf.Require {
+				m, ok := replacement(workReplaces, r.Mod)
+				if !ok {
+					m, _ = replacement(modReplaces, r.Mod)
+				}
+				if m.Version == "" {
+					continue // local directory replacement
+				}
+				needed[need{m.Path, m.Version, ".mod"}] = modPath
+				required[m.Path+"@"+m.Version] = true
+			}
+		}
+		sumPaths = append(sumPaths, filepath.Join(filepath.Dir(modPath), "go.sum"))
+	}
+
+	for _, sumPath := range sumPaths {
+		data, err := os.ReadFile(sumPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			fields := strings.Fields(sc.Text())
+			if len(fields) != 3 {
+				continue
+			}
+			n := need{fields[0], fields[1], ".zip"}
+			if v, ok := strings.CutSuffix(fields[1], "/go.mod"); ok {
+				n = need{fields[0], v, ".mod"}
+			}
+			if _, ok := needed[n]; !ok {
+				needed[n] = sumPath
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	var missing []MissingModule
+	for n, neededBy := range needed {
+		if available(sourceDir, opts.Proxy != "", n.path, n.version, n.ext) {
+			continue
+		}
+		missing = append(missing, MissingModule{Path: n.path, Version: n.version, Ext: n.ext, NeededBy: neededBy,
+			SumOnly: !required[n.path+"@"+n.version]})
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		a, b := missing[i], missing[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Ext < b.Ext
+	})
+	return missing, sourceDir, nil
+}
+
+// replacesByPath groups replace directives by the module path they replace.
+func replacesByPath(replaces []*modfile.Replace) map[string][]*modfile.Replace {
+	byPath := make(map[string][]*modfile.Replace)
+	for _, r := range replaces {
+		byPath[r.Old.Path] = append(byPath[r.Old.Path], r)
+	}
+	return byPath
+}
+
+// replacement returns what m resolves to under replaces: the replace of its
+// exact version, else the one for any version of its path. It returns m and
+// false if neither exists.
+func replacement(replaces map[string][]*modfile.Replace, m module.Version) (module.Version, bool) {
+	var wildcard *modfile.Replace
+	for _, r := range replaces[m.Path] {
+		if r.Old.Version == m.Version {
+			return r.New, true
+		}
+		if r.Old.Version == "" {
+			wildcard = r
+		}
+	}
+	if wildcard != nil {
+		return wildcard.New, true
+	}
+	return m, false
+}
+
+// available reports whether path@version has the given file in sourceDir,
+// which uses the GOPROXY layout. An extracted copy in the module cache also
+// satisfies a ".zip" need.
+func available(sourceDir string, isProxy bool, path string, version string, ext string) bool {
+	escPath, err := module.EscapePath(path)
+	if err != nil {
+		return false
+	}
+	escVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return false
+	}
+	if fileExists(filepath.Join(sourceDir, filepath.FromSlash(escPath), "@v", escVersion+ext)) {
+		return true
+	}
+	if ext == ".zip" && !isProxy {
+		// sourceDir is GOMODCACHE/cache/download
+		extracted := filepath.Join(filepath.Dir(filepath.Dir(sourceDir)), filepath.FromSlash(escPath)+"@"+escVersion)
+		return dirExists(extracted)
+	}
+	return false
+}
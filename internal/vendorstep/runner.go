@@ -0,0 +1,141 @@
+package vendorstep
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Cmd is one child command to run.
+type Cmd struct {
+	Dir  string
+	Env  []string
+	Args []string // Args[0] is the program
+}
+
+// Result is the outcome of a Cmd. Err is nil on exit status 0; ExitCode is
+// -1 when the command could not be started.
+type Result struct {
+	Stdout   string
+	Combined string // stdout and stderr interleaved
+	ExitCode int
+	Err      error
+}
+
+// Runner runs child commands for the vendor stage.
+type Runner interface {
+	Run(c Cmd) Result
+}
+
+// ExecRunner runs commands directly with os/exec.
+type ExecRunner struct{}
+
+func (ExecRunner) Run(c Cmd) Result {
+	var stdout bytes.Buffer
+	var combined lockedBuffer
+	cmd := exec.Command(c.Args[0], c.Args[1:]...)
+	cmd.Dir = c.Dir
+	cmd.Env = c.Env
+	cmd.Stdout = io.MultiWriter(&stdout, &combined)
+	cmd.Stderr = &combined
+
+	err := cmd.Run()
+	res := Result{Stdout: stdout.String(), Combined: combined.String(), Err: err}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		res.ExitCode = exitErr.ExitCode()
+	default:
+		res.ExitCode = -1
+		res.Combined += err.Error()
+	}
+	return res
+}
+
+// Recorder is a fake Runner that records every command instead of running
+// it. Respond, if set, supplies the result; otherwise commands succeed with
+// no output.
+type Recorder struct {
+	Respond func(c Cmd) Result
+
+	mu    sync.Mutex
+	calls []Cmd
+}
+
+func (r *Recorder) Run(c Cmd) Result {
+	r.mu.Lock()
+	r.calls = append(r.calls, c)
+	r.mu.Unlock()
+	if r.Respond != nil {
+		return r.Respond(c)
+	}
+	return Result{}
+}
+
+// Calls returns the commands run so far, in call order.
+func (r *Recorder) Calls() []Cmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Cmd(nil), r.calls...)
+}
+
+// Sandbox kinds accepted by NewSandboxRunner.
+const (
+	SandboxNone    = "none"
+	SandboxBwrap   = "bwrap"
+	SandboxUnshare = "unshare"
+)
+
+// SandboxRunner runs commands through Inner inside a sandbox without network
+// access, for packaging untrusted repositories.
+//   - bwrap: read-only root filesystem, private /tmp, only Writable paths
+//     bind-mounted read-write, all namespaces unshared
+//   - unshare: new user and network namespace only
+type SandboxRunner struct {
+	Inner    Runner
+	Kind     string
+	Writable []string // e.g. the package copy, GOMODCACHE and GOCACHE
+}
+
+// NewSandboxRunner wraps inner for the given sandbox kind. SandboxNone and
+// "" return inner unchanged. The sandbox binary must be on PATH.
+func NewSandboxRunner(inner Runner, kind string, writable []string) (Runner, error) {
+	switch kind {
+	case "", SandboxNone:
+		return inner, nil
+	case SandboxBwrap, SandboxUnshare:
+		if _, err := exec.LookPath(kind); err != nil {
+			return nil, fmt.Errorf("sandbox %s: %w", kind, err)
+		}
+		return &SandboxRunner{Inner: inner, Kind: kind, Writable: writable}, nil
+	}
+	return nil, fmt.Errorf("unknown sandbox %q (want none, bwrap or unshare)", kind)
+}
+
+func (s *SandboxRunner) Run(c Cmd) Result {
+	var prefix []string
+	switch s.Kind {
+	case SandboxBwrap:
+		prefix = []string{"bwrap", "--unshare-all", "--die-with-parent",
+			"--ro-bind", "/", "/", "--dev", "/dev", "--proc", "/proc", "--tmpfs", "/tmp"}
+		for _, p := range s.Writable {
+			prefix = append(prefix, "--bind", p, p)
+		}
+		prefix = append(prefix, "--chdir", c.Dir, "--")
+	case SandboxUnshare:
+		prefix = []string{"unshare", "--user", "--map-root-user", "--net", "--"}
+	}
+	wrapped := c
+	wrapped.Args = append(prefix, c.Args...)
+	return s.Inner.Run(wrapped)
+}
+
+// String describes the sandbox for logs.
+func (s *SandboxRunner) String() string {
+	return s.Kind + " (writable: " + strings.Join(s.Writable, ", ") + ")"
+}
@@ -0,0 +1,145 @@
+package vendorstep
+
+import (
+	"fmt"
+	"go/version"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/workedit"
+
+	"golang.org/x/mod/modfile"
+)
+
+// GoRequirement is the go version the files to vendor need.
+type GoRequirement struct {
+	Go        string // highest go directive, e.g. "1.22.3"; at least workedit.MinWorkVendorGo with a workspace
+	From      string // file declaring Go, or "go work vendor"
+	Toolchain string // highest toolchain directive, e.g. "go1.23.4", or ""
+}
+
+// RequiredGo reads the go and toolchain directives of the go.work files, the
+// go.mod files and the go.mod of every workspace member. The go command
+// refuses to work on a file whose go line is newer than itself; a toolchain
+// line only asks for a newer toolchain when GOTOOLCHAIN allows switching.
+func RequiredGo(workFiles []string, modFiles []string, workspaces workedit.Workspaces) (GoRequirement, error) {
+	var req GoRequirement
+	raise := func(v string, from string) {
+		if req.Go == "" || version.Compare("go"+v, "go"+req.Go) > 0 {
+			req.Go, req.From = v, from
+		}
+	}
+	if len(workFiles) > 0 {
+		raise(workedit.MinWorkVendorGo, "go work vendor")
+	}
+
+	files := append([]string(nil), workFiles...)
+	seen := make(map[string]bool)
+	for _, m := range modFiles {
+		seen[filepath.Clean(m)] = true
+		files = append(files, m)
+	}
+	for d := range workspaces.AllModuleDirs() {
+		if m := filepath.Join(d, "go.mod"); !seen[m] {
+			seen[m] = true
+			files = append(files, m)
+		}
+	}
+
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if os.IsNotExist(err) {
+			continue // a use without go.mod, reported by the rewrite check
+		}
+		if err != nil {
+			return req, err
+		}
+		var goLine *modfile.Go
+		var toolchainLine *modfile.Toolchain
+		if filepath.Base(f) == "go.work" {
+			wf, err := modfile.ParseWork(f, data, nil)
+			if err != nil {
+				return req, err
+			}
+			goLine, toolchainLine = wf.Go, wf.Toolchain
+		} else {
+			mf, err := modfile.Parse(f, data, nil)
+			if err != nil {
+				return req, err
+			}
+			goLine, toolchainLine = mf.Go, mf.Toolchain
+		}
+		if goLine != nil {
+			raise(goLine.Version, f)
+		}
+		if toolchainLine != nil && version.Compare(toolchainLine.Name, req.Toolchain) > 0 {
+			req.Toolchain = toolchainLine.Name
+		}
+	}
+	return req, nil
+}
+
+// SelectGoRoot returns the GOROOT to run go from and its version: "" for
+// the go on PATH, else one of candidates, tried in order. The first that
+// also satisfies req.Toolchain is preferred, then the first new enough for
+// req.Go. The error lists every version found.
+func (o Options) SelectGoRoot(req GoRequirement, candidates []string) (goRoot string, goVersion string, err error) {
+	type found struct{ root, version string }
+	var usable []found
+	var tried []string
+	for _, root := range append([]string{""}, candidates...) {
+		opts := o
+		opts.GoRoot = root
+		where := "go on PATH"
+		if root != "" {
+			where = root
+		}
+		v, err := opts.GoEnvValue("GOVERSION")
+		if err != nil {
+			tried = append(tried, fmt.Sprintf("%s: %v", where, err))
+			continue
+		}
+		tried = append(tried, fmt.Sprintf("%s is %s", where, v))
+		if req.Go == "" || GoAtLeast(v, "go"+req.Go) {
+			usable = append(usable, found{root, v})
+		}
+	}
+	for _, f := range usable {
+		if req.Toolchain == "" || GoAtLeast(f.version, req.Toolchain) {
+			return f.root, f.version, nil
+		}
+	}
+	if len(usable) > 0 {
+		return usable[0].root, usable[0].version, nil
+	}
+	from := req.From
+	if o.Report != nil {
+		from = o.Report.Rel(from)
+	}
+	return "", "", fmt.Errorf("go %s required by %s, but no toolchain is new enough (%s); install one and pass its GOROOT with -goroot",
+		req.Go, from, strings.Join(tried, "; "))
+}
+
+// GoAtLeast reports whether the toolchain version have (a GOVERSION) is at
+// least want. A development toolchain reports "devel go1.N-<rev> <date>"
+// (or "devel <rev>"), which go/version cannot order; it is taken to support
+// every go1.N version, and anything at all if it names no release.
+func GoAtLeast(have string, want string) bool {
+	if rest, ok := strings.CutPrefix(have, "devel "); ok {
+		base, _, _ := strings.Cut(strings.Fields(rest)[0], "-")
+		if !version.IsValid(base) {
+			return true
+		}
+		return version.Compare(base, version.Lang(want)) >= 0
+	}
+	return version.Compare(have, want) >= 0
+}
+
+// goCommand returns the go binary to run: the one in GoRoot, or go from PATH.
+func (o Options) goCommand() string {
+	if o.GoRoot == "" {
+		return "go"
+	}
+	return filepath.Join(o.GoRoot, "bin", "go")
+}
@@ -0,0 +1,140 @@
+package vendorstep
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/modulestxt"
+	"github.com/relaxnow/vc-gowork-poc/internal/report"
+	"github.com/relaxnow/vc-gowork-poc/internal/workedit"
+
+	"golang.org/x/mod/modfile"
+)
+
+const stageVendorCheck = "vendor-check"
+
+// ValidateVendoring checks the vendor directories produced by RunVendorSteps
+// and records mismatches in rep:
+//   - modules.txt is missing although go.mod requires modules
+//   - a package listed in modules.txt has no directory under vendor/
+//   - a go.mod require is not marked "## explicit" in modules.txt, or the
+//     reverse
+//   - for standalone modules, the explicit version differs from go.mod
+func ValidateVendoring(workFiles []string, modFiles []string, workspaces workedit.Workspaces, rep *report.Report) error {
+	for _, workPath := range workFiles {
+		workDir := filepath.Dir(workPath)
+		var memberMods []string
+		for _, modDir := range workspaces.ModuleDirs(workPath) {
+			if fileExists(filepath.Join(modDir, "go.mod")) {
+				memberMods = append(memberMods, filepath.Join(modDir, "go.mod"))
+			}
+		}
+		if err := validateVendorDir(workDir, memberMods, true, rep.Module(workDir, report.KindWorkspace)); err != nil {
+			return err
+		}
+	}
+
+	for _, modPath := range modFiles {
+		modDir := filepath.Dir(modPath)
+		if workspaces.IsMember(modDir) {
+			continue
+		}
+		if err := validateVendorDir(modDir, []string{modPath}, false, rep.Module(modDir, report.KindModule)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateVendorDir checks dir/vendor against the requires of modPaths.
+// In a workspace the vendored version is the one selected across all
+// members, so only presence is compared.
+func validateVendorDir(dir string, modPaths []string, workspace bool, rm *report.Module) error {
+	requires := make(map[string]string) // module path -> version
+	memberPaths := make(map[string]bool)
+	for _, modPath := range modPaths {
+		data, err := os.ReadFile(modPath)
+		if err != nil {
+			return err
+		}
+		mf, err := modfile.ParseLax(modPath, data, nil)
+		if err != nil {
+			return err
+		}
+		if mf.Module != nil {
+			memberPaths[mf.Module.Mod.Path] = true
+		}
+		for _, r := range mf.Require {
+			requires[r.Mod.Path] = r.Mod.Version
+		}
+	}
+	if workspace {
+		// Workspace members are used from source, never vendored.
+		for p := range memberPaths {
+			delete(requires, p)
+		}
+	}
+
+	vendorDir := filepath.Join(dir, "vendor")
+	modulesTxt := filepath.Join(vendorDir, "modules.txt")
+	if !fileExists(modulesTxt) {
+		if len(requires) > 0 {
+			rm.Addf(stageVendorCheck, "vendor/modules.txt", "missing although %d modules are required", len(requires))
+			fmt.Fprintf(os.Stderr, "warning: %s: vendor/modules.txt missing\n", dir)
+		}
+		return nil
+	}
+
+	mt, err := modulestxt.ParseFile(modulesTxt)
+	if err != nil {
+		rm.Addf(stageVendorCheck, "vendor/modules.txt", "unparsable: %v", err)
+		return nil
+	}
+
+	before := len(rm.Issues)
+	explicit := make(map[string]string)
+	for _, m := range mt.Modules {
+		if m.Explicit {
+			explicit[m.Path] = m.Version
+		}
+		for _, pkg := range m.Packages {
+			if !dirExists(filepath.Join(vendorDir, filepath.FromSlash(pkg))) {
+				rm.Addf(stageVendorCheck, "vendor/modules.txt", "package %s of %s has no directory under vendor/", pkg, m.ID())
+			}
+		}
+	}
+	for _, p := range sortedKeys(requires) {
+		v, ok := explicit[p]
+		switch {
+		case !ok:
+			rm.Addf(stageVendorCheck, "vendor/modules.txt", "%s is required by go.mod but not marked explicit", p)
+		case !workspace && v != requires[p]:
+			rm.Addf(stageVendorCheck, "vendor/modules.txt", "%s is required at %s but vendored at %s", p, requires[p], v)
+		}
+	}
+	for _, p := range sortedKeys(explicit) {
+		if _, ok := requires[p]; !ok && !memberPaths[p] {
+			rm.Addf(stageVendorCheck, "vendor/modules.txt", "%s is marked explicit but not required by go.mod", p)
+		}
+	}
+	if n := len(rm.Issues) - before; n > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %s: %d vendoring mismatches\n", dir, n)
+	}
+	return nil
+}
+
+func dirExists(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.IsDir()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
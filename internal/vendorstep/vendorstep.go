@@ -1,79 +1,237 @@
 package vendorstep
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sync"
 
+	"github.com/relaxnow/vc-gowork-poc/internal/report"
 	"github.com/relaxnow/vc-gowork-poc/internal/util"
+	"github.com/relaxnow/vc-gowork-poc/internal/workedit"
 )
 
-// RunVendorSteps runs vendoring with tidying first.
+// Strategy says whether go.mod/go.sum are tidied before vendoring.
+type Strategy string
+
+const (
+	// StrategyAsIs vendors the modules exactly as committed.
+	StrategyAsIs Strategy = "as-is"
+	// StrategyTidy runs "go mod tidy" first, which may rewrite go.mod/go.sum.
+	StrategyTidy Strategy = "tidy"
+	// StrategyTidyCheck runs "go mod tidy -diff" and reports drift without
+	// modifying anything.
+	StrategyTidyCheck Strategy = "tidy-check"
+)
+
+// ParseStrategy parses a strategy name as accepted on the command line.
+func ParseStrategy(s string) (Strategy, error) {
+	switch st := Strategy(s); st {
+	case StrategyAsIs, StrategyTidy, StrategyTidyCheck:
+		return st, nil
+	}
+	return "", fmt.Errorf("unknown vendor strategy %q (want as-is, tidy or tidy-check)", s)
+}
+
+func (o Options) runner() Runner {
+	if o.Runner == nil {
+		return ExecRunner{}
+	}
+	return o.Runner
+}
+
+func (s Strategy) isAsIs() bool { return s == "" || s == StrategyAsIs }
+
+// Options configures the go commands run by the vendor stage.
+type Options struct {
+	// Strategy selects tidying before vendoring, StrategyAsIs if empty.
+	Strategy Strategy
+	// Offline keeps go commands off the network: GOPROXY=off, GOFLAGS=-mod=mod
+	// and GOSUMDB=off, so go.sum is the only checksum source.
+	Offline bool
+	// Proxy is a file:// GOPROXY directory (or plain path) to resolve modules
+	// from instead of the network. Implies Offline.
+	Proxy string
+	// ModuleCache overrides GOMODCACHE.
+	ModuleCache string
+	// Toolchain is the GOTOOLCHAIN policy, "local" if empty.
+	Toolchain string
+	// GoRoot is the GOROOT of the go command to run, the go on PATH if empty.
+	GoRoot string
+	// ExtraEnv holds explicit KEY=VALUE settings applied last, e.g. GOPRIVATE.
+	ExtraEnv []string
+	// Jobs is the number of workspaces/modules vendored in parallel, at least 1.
+	Jobs int
+	// Runner runs the go commands, ExecRunner if nil.
+	Runner Runner
+	// Report receives the effective environment and per-module findings.
+	Report *report.Report
+}
+
+// RunVendorSteps runs vendoring, tidying first according to opts.Strategy.
 // - For each go.work file directory:
-//   - Run "go mod tidy" in every module dir that appears in usedModuleDirs (and has a go.mod)
+//   - Tidy every module dir used by that go.work (and has a go.mod)
 //   - Run "go work vendor" in the go.work directory
 //
-// - For each go.mod not covered by any go.work use:
-//   - Run "go mod tidy" then "go mod vendor"
-func RunVendorSteps(workFiles []string, modFiles []string, usedModuleDirs map[string]struct{}) error {
+// - For each go.mod whose own directory is not a go.work use:
+//   - Tidy, then run "go mod vendor"
+//   - Modules nested inside a used module are included here
+//   - A module next to a go.work that does not use it is reported instead,
+//     as that directory's vendor/ belongs to the workspace
+//
+// Workspaces and standalone modules are independent units run by up to
+// opts.Jobs workers. Each unit's output is buffered and printed in the order
+// above once the unit finishes. A module used by several workspaces is tidied
+// only once, before any of those workspaces vendors.
+//
+// Commands run in a sanitized environment, see Options.EffectiveEnv.
+// GOWORK is the rewritten go.work for "go work vendor" and off otherwise.
+func RunVendorSteps(workFiles []string, modFiles []string, workspaces workedit.Workspaces, opts Options) error {
+	opts.Report.Environment = opts.EffectiveEnv()
+
+	tidyOnce := make(map[string]*sync.Once)
+	for modDir := range workspaces.AllModuleDirs() {
+		tidyOnce[modDir] = new(sync.Once)
+	}
+
+	var units []func(w io.Writer)
+
 	// 1) For each workspace, tidy all used modules first, then vendor at the workspace root.
 	for _, workPath := range workFiles {
-		workDir := filepath.Dir(workPath)
-
-		// Tidy all modules referenced by any go.work use entry
-		for modDir := range usedModuleDirs {
-			// Only tidy those that actually exist and contain a go.mod file
-			if fileExists(filepath.Join(modDir, "go.mod")) {
-				fmt.Printf("[work] tidy in %s (referenced by go.work)\n", modDir)
-				if err := runCmd(modDir, "go", "mod", "tidy"); err != nil {
-					fmt.Fprintf(os.Stderr, "warning: go mod tidy failed in %s: %v\n", modDir, err)
+		units = append(units, func(w io.Writer) {
+			workDir := filepath.Dir(workPath)
+
+			// Tidy the modules referenced by this go.work's use entries
+			for _, modDir := range workspaces.ModuleDirs(workPath) {
+				// Only tidy those that actually exist and contain a go.mod file
+				if opts.Strategy.isAsIs() || !fileExists(filepath.Join(modDir, "go.mod")) {
+					continue
+				}
+				done := false
+				tidyOnce[modDir].Do(func() {
+					done = true
+					tidy(w, opts, "[work]", modDir)
+				})
+				if !done {
+					fmt.Fprintf(w, "[work] tidy skipped for %s (already done for another go.work)\n", modDir)
 				}
 			}
-		}
 
-		// Now vendor at the workspace root
-		fmt.Printf("[work] vendor in %s\n", workDir)
-		if err := runCmd(workDir, "go", "work", "vendor"); err != nil {
-			fmt.Fprintf(os.Stderr, "warning: go work vendor failed in %s: %v\n", workDir, err)
-		}
+			// Now vendor at the workspace root
+			fmt.Fprintf(w, "[work] vendor in %s\n", workDir)
+			rm := opts.Report.Module(workDir, report.KindWorkspace)
+			rm.GOWORK = opts.Report.Rel(workPath)
+			if err := execCmd(w, opts, rm, opts.commandEnv(workPath), workDir, opts.goCommand(), "work", "vendor").Err; err != nil {
+				fmt.Fprintf(w, "warning: go work vendor failed in %s: %v\n", workDir, err)
+				rm.Addf("vendor", "go.work", "go work vendor failed: %v", err)
+			}
+		})
 	}
 
 	// 2) For standalone modules not covered by any go.work use, tidy then vendor.
-	skipModDirs := make(map[string]struct{}, len(usedModuleDirs))
-	for d := range usedModuleDirs {
-		skipModDirs[filepath.Clean(d)] = struct{}{}
+	// Membership is exact: a go.mod nested inside a member (tools/, say) is
+	// its own module, left out of the member's vendor directory.
+	memberDirs := workspaces.AllModuleDirs()
+	workDirs := make(map[string]string) // go.work dir -> go.work
+	for _, workPath := range workFiles {
+		workDirs[filepath.Dir(workPath)] = workPath
 	}
 	for _, modPath := range modFiles {
 		modDir := filepath.Dir(modPath)
-		if util.IsUnderAny(modDir, skipModDirs) {
-			fmt.Printf("[mod ] vendor skipped for %s (covered by go.work use)\n", modDir)
+		if workspaces.IsMember(modDir) {
+			units = append(units, func(w io.Writer) {
+				fmt.Fprintf(w, "[mod ] vendor skipped for %s (covered by go.work use)\n", modDir)
+			})
 			continue
 		}
-
-		// Tidy then vendor
-		fmt.Printf("[mod ] tidy in %s\n", modDir)
-		if err := runCmd(modDir, "go", "mod", "tidy"); err != nil {
-			fmt.Fprintf(os.Stderr, "warning: go mod tidy failed in %s: %v\n", modDir, err)
+		// go work vendor already writes vendor/ here; a concurrent go mod
+		// vendor would race it for the same directory
+		if workPath, ok := workDirs[modDir]; ok {
+			opts.Report.Module(modDir, report.KindModule).Addf("vendor", "go.mod",
+				"module is not in the use list of %s next to it; vendor/ belongs to the workspace, module not vendored on its own", opts.Report.Rel(workPath))
+			units = append(units, func(w io.Writer) {
+				fmt.Fprintf(w, "warning: %s: module not used by the go.work in its directory, vendor skipped\n", modPath)
+			})
+			continue
 		}
+		nested := util.IsUnderAny(modDir, memberDirs)
 
-		fmt.Printf("[mod ] vendor in %s\n", modDir)
-		if err := runCmd(modDir, "go", "mod", "vendor"); err != nil {
-			fmt.Fprintf(os.Stderr, "warning: go mod vendor failed in %s: %v\n", modDir, err)
-		}
+		units = append(units, func(w io.Writer) {
+			if nested {
+				fmt.Fprintf(w, "[mod ] %s is nested in a go.work member but not used; vendoring it on its own\n", modDir)
+			}
+			// Tidy then vendor
+			tidy(w, opts, "[mod ]", modDir)
+
+			fmt.Fprintf(w, "[mod ] vendor in %s\n", modDir)
+			rm := opts.Report.Module(modDir, report.KindModule)
+			if err := execCmd(w, opts, rm, opts.commandEnv(""), modDir, opts.goCommand(), "mod", "vendor").Err; err != nil {
+				fmt.Fprintf(w, "warning: go mod vendor failed in %s: %v\n", modDir, err)
+				rm.Addf("vendor", "go.mod", "go mod vendor failed: %v", err)
+			}
+		})
 	}
 
+	runUnits(opts.Jobs, units)
 	return nil
 }
 
 /* ---------- helpers ---------- */
 
-func runCmd(dir string, name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	cmd.Dir = dir
-	cmd.Stdout = util.Stdout()
-	cmd.Stderr = util.Stderr()
-	return cmd.Run()
+// runUnits runs units on up to jobs workers and copies each unit's buffered
+// output to stdout in unit order.
+func runUnits(jobs int, units []func(w io.Writer)) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	bufs := make([]bytes.Buffer, len(units))
+	done := make([]chan struct{}, len(units))
+	sem := make(chan struct{}, jobs)
+	for i, run := range units {
+		done[i] = make(chan struct{})
+		go func() {
+			sem <- struct{}{}
+			defer func() {
+				<-sem
+				close(done[i])
+			}()
+			run(&bufs[i])
+		}()
+	}
+	for i := range units {
+		<-done[i]
+		_, _ = util.Stdout().Write(bufs[i].Bytes())
+	}
+}
+
+// tidy applies opts.Strategy to the module in modDir. Drift found by
+// tidy-check is recorded in the report with the diff.
+func tidy(w io.Writer, opts Options, tag string, modDir string) {
+	rm := opts.Report.Module(modDir, report.KindModule)
+	switch opts.Strategy {
+	case StrategyTidy:
+		fmt.Fprintf(w, "%s tidy in %s\n", tag, modDir)
+		if err := execCmd(w, opts, rm, opts.commandEnv(""), modDir, opts.goCommand(), "mod", "tidy").Err; err != nil {
+			fmt.Fprintf(w, "warning: go mod tidy failed in %s: %v\n", modDir, err)
+			rm.Addf("tidy", "go.mod", "go mod tidy failed: %v", err)
+		}
+	case StrategyTidyCheck:
+		fmt.Fprintf(w, "%s tidy-check in %s\n", tag, modDir)
+		res := execCmd(w, opts, rm, opts.commandEnv(""), modDir, opts.goCommand(), "mod", "tidy", "-diff")
+		switch {
+		case res.Err == nil:
+		case res.ExitCode == 1 && res.Stdout != "":
+			// Exit status 1 with a diff on stdout means go.mod/go.sum are not tidy.
+			rm.TidyDiff = res.Stdout
+			rm.Addf("tidy-check", "go.mod", "go.mod/go.sum are not tidy, see tidyDiff")
+			fmt.Fprintf(w, "warning: %s is not tidy (diff recorded in the report)\n", modDir)
+		default:
+			fmt.Fprintf(w, "warning: go mod tidy -diff failed in %s: %v\n", modDir, res.Err)
+			rm.Addf("tidy-check", "go.mod", "go mod tidy -diff failed: %v", res.Err)
+		}
+	}
 }
 
 func fileExists(p string) bool {
@@ -0,0 +1,213 @@
+package vendorstep
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/report"
+	"github.com/relaxnow/vc-gowork-poc/internal/workedit"
+)
+
+// writeFiles writes files, keyed by slash path relative to root.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeTree creates a workspace using module a, plus a standalone module b.
+func writeTree(t *testing.T) (root string, workFiles []string, modFiles []string, workspaces workedit.Workspaces) {
+	t.Helper()
+	root = t.TempDir()
+	files := map[string]string{
+		"go.work":  "go 1.22\n\nuse ./a\n",
+		"a/go.mod": "module example.com/a\n\ngo 1.22\n",
+		"b/go.mod": "module example.com/b\n\ngo 1.22\n",
+	}
+	writeFiles(t, root, files)
+	workPath := filepath.Join(root, "go.work")
+	workspaces = workedit.Workspaces{workPath: {filepath.Join(root, "a"): {}}}
+	return root, []string{workPath}, []string{filepath.Join(root, "a", "go.mod"), filepath.Join(root, "b", "go.mod")}, workspaces
+}
+
+func TestRunVendorSteps(t *testing.T) {
+	tests := []struct {
+		strategy Strategy
+		want     map[string][]string // dir relative to root -> "args GOWORK=..." in order
+	}{
+		{StrategyAsIs, map[string][]string{
+			".": {"go work vendor GOWORK=go.work"},
+			"b": {"go mod vendor GOWORK=off"},
+		}},
+		{StrategyTidy, map[string][]string{
+			".": {"go work vendor GOWORK=go.work"},
+			"a": {"go mod tidy GOWORK=off"},
+			"b": {"go mod tidy GOWORK=off", "go mod vendor GOWORK=off"},
+		}},
+		{StrategyTidyCheck, map[string][]string{
+			".": {"go work vendor GOWORK=go.work"},
+			"a": {"go mod tidy -diff GOWORK=off"},
+			"b": {"go mod tidy -diff GOWORK=off", "go mod vendor GOWORK=off"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			root, workFiles, modFiles, workspaces := writeTree(t)
+			rec := &Recorder{}
+			opts := Options{
+				Strategy: tt.strategy,
+				Jobs:     2,
+				Runner:   rec,
+				Report:   report.New(root, root),
+			}
+			if err := RunVendorSteps(workFiles, modFiles, workspaces, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string][]string)
+			for _, c := range rec.Calls() {
+				rel, err := filepath.Rel(root, c.Dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got[filepath.ToSlash(rel)] = append(got[filepath.ToSlash(rel)], strings.Join(c.Args, " ")+" GOWORK="+gowork(root, c.Env))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("commands by directory:\n got %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// gowork returns the GOWORK set in env, relative to root if it is a file.
+func gowork(root string, env []string) string {
+	for _, kv := range env {
+		if v, ok := strings.CutPrefix(kv, "GOWORK="); ok {
+			if rel, err := filepath.Rel(root, v); err == nil && filepath.IsAbs(v) {
+				return filepath.ToSlash(rel)
+			}
+			return v
+		}
+	}
+	return ""
+}
+
+func TestGoEnvValueUsesRunner(t *testing.T) {
+	rec := &Recorder{Respond: func(c Cmd) Result { return Result{Stdout: "/cache\n"} }}
+	v, err := Options{Runner: rec, GoRoot: "/opt/go"}.GoEnvValue("GOMODCACHE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "/cache" {
+		t.Errorf("GoEnvValue = %q, want /cache", v)
+	}
+	calls := rec.Calls()
+	want := []string{filepath.Join("/opt/go", "bin", "go"), "env", "GOMODCACHE"}
+	if len(calls) != 1 || !reflect.DeepEqual(calls[0].Args, want) {
+		t.Errorf("calls = %v, want one running %v", calls, want)
+	}
+}
+
+func TestGoAtLeast(t *testing.T) {
+	tests := []struct {
+		have, want string
+		ok         bool
+	}{
+		{"go1.23.4", "go1.22", true},
+		{"go1.21.0", "go1.22", false},
+		{"go1.22.0", "go1.22.3", false},
+		{"devel go1.24-abcdef Tue Jan 7 10:00:00 2025 +0000", "go1.24.2", true},
+		{"devel go1.24-abcdef Tue Jan 7 10:00:00 2025 +0000", "go1.25", false},
+		{"devel +abcdef", "go1.99", true},
+	}
+	for _, tt := range tests {
+		if got := GoAtLeast(tt.have, tt.want); got != tt.ok {
+			t.Errorf("GoAtLeast(%q, %q) = %v, want %v", tt.have, tt.want, got, tt.ok)
+		}
+	}
+}
+
+func TestCheckModuleSourceWorkReplace(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"go.work":    "go 1.22\n\nuse ./a\n\nreplace example.com/lib => ./lib\n",
+		"a/go.mod":   "module example.com/a\n\ngo 1.22\n\nrequire example.com/lib v1.0.0\n",
+		"lib/go.mod": "module example.com/lib\n\ngo 1.22\n",
+	}
+	writeFiles(t, root, files)
+	workPath := filepath.Join(root, "go.work")
+	workspaces := workedit.Workspaces{workPath: {filepath.Join(root, "a"): {}}}
+	opts := Options{Proxy: t.TempDir()}
+
+	modFiles := []string{filepath.Join(root, "a", "go.mod"), filepath.Join(root, "lib", "go.mod")}
+	missing, _, err := CheckModuleSource([]string{workPath}, modFiles, workspaces, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("missing = %v, want none: go.work replaces example.com/lib with a directory", missing)
+	}
+
+	// Tidied alone, the member needs the version it requires
+	opts.Strategy = StrategyTidy
+	missing, _, err = CheckModuleSource([]string{workPath}, modFiles, workspaces, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 1 || missing[0].Path != "example.com/lib" || missing[0].Version != "v1.0.0" || missing[0].SumOnly {
+		t.Errorf("missing = %v, want example.com/lib v1.0.0 required", missing)
+	}
+}
+
+func TestRunVendorStepsModuleBesideGoWork(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.work":  "go 1.22\n\nuse ./a\n",
+		"go.mod":   "module example.com/root\n\ngo 1.22\n",
+		"a/go.mod": "module example.com/a\n\ngo 1.22\n",
+	})
+	workPath := filepath.Join(root, "go.work")
+	workspaces := workedit.Workspaces{workPath: {filepath.Join(root, "a"): {}}}
+	rec := &Recorder{}
+	opts := Options{Strategy: StrategyAsIs, Jobs: 2, Runner: rec, Report: report.New(root, root)}
+	modFiles := []string{filepath.Join(root, "go.mod"), filepath.Join(root, "a", "go.mod")}
+	if err := RunVendorSteps([]string{workPath}, modFiles, workspaces, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, c := range rec.Calls() {
+		got = append(got, strings.Join(c.Args, " "))
+	}
+	if want := []string{"go work vendor"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %v, want %v: the root module shares vendor/ with the workspace", got, want)
+	}
+	if issues := opts.Report.Module(root, report.KindModule).Issues; len(issues) != 1 {
+		t.Errorf("root module issues = %v, want one", issues)
+	}
+}
+
+func TestCommandEnvKeepsNonGoVars(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "/creds.json")
+	t.Setenv("GOPRIVATE", "example.com/private")
+	env := Options{}.commandEnv("")
+	if !slices.Contains(env, "GOOGLE_APPLICATION_CREDENTIALS=/creds.json") {
+		t.Errorf("GOOGLE_APPLICATION_CREDENTIALS not passed through: %v", env)
+	}
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "GOPRIVATE=") {
+			t.Errorf("GOPRIVATE inherited: %v", env)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package workedit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/report"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// ResolveWorkspaceReplaces computes, for every workspace, the replaces in
+// force the way the go command does, and records them in rep:
+//   - a go.work replace of a module path, for any version, wins over every
+//     member go.mod replace of that path; the member's is ignored in the
+//     workspace but still used by commands run on the module alone, such as
+//     go mod tidy
+//   - members replacing the same module with different targets, without a
+//     go.work replace settling it, make go work vendor fail
+//
+// Both cases are reported. With resolve, member replaces overridden by
+// go.work are rewritten to the go.work target so the module gives the same
+// result alone and in the workspace; a member shared by workspaces that
+// disagree is left alone, for every one of them, and reported.
+func ResolveWorkspaceReplaces(workspaces Workspaces, resolve bool, rep *report.Report) error {
+	workPaths := make([]string, 0, len(workspaces))
+	for w := range workspaces {
+		workPaths = append(workPaths, w)
+	}
+	sort.Strings(workPaths)
+
+	// go.work replaces are keyed by module path: one for any version of a
+	// path overrides every member replace of that path.
+	fromWorks := make(map[string]map[string][]replaceSource, len(workPaths))
+	for _, workPath := range workPaths {
+		data, err := os.ReadFile(workPath)
+		if err != nil {
+			return err
+		}
+		wf, err := modfile.ParseWork(workPath, data, nil)
+		if err != nil {
+			return err
+		}
+		workDir := filepath.Dir(workPath)
+		fromWork := make(map[string][]replaceSource)
+		for _, r := range wf.Replace {
+			fromWork[r.Old.Path] = append(fromWork[r.Old.Path], replaceSource{workPath, r.Old, replaceTarget(workDir, r.New), r.New})
+		}
+		fromWorks[workPath] = fromWork
+	}
+
+	// Before rewriting anything, find the member replaces that workspaces
+	// sharing the member would set to different targets.
+	workTargets := make(map[string]map[string]bool) // member go.mod + old -> go.work targets
+	for _, workPath := range workPaths {
+		for _, modDir := range workspaces.ModuleDirs(workPath) {
+			modPath := filepath.Join(modDir, "go.mod")
+			data, err := os.ReadFile(modPath)
+			if err != nil {
+				continue
+			}
+			mf, err := modfile.Parse(modPath, data, nil)
+			if err != nil {
+				return err
+			}
+			for _, r := range mf.Replace {
+				if ws, ok := fromWorks[workPath][r.Old.Path]; ok {
+					key := modPath + "\x00" + r.Old.String()
+					if workTargets[key] == nil {
+						workTargets[key] = make(map[string]bool)
+					}
+					workTargets[key][workReplaceFor(ws, r.Old).target] = true
+				}
+			}
+		}
+	}
+
+	for _, workPath := range workPaths {
+		workDir := filepath.Dir(workPath)
+		rm := rep.Module(workDir, report.KindWorkspace)
+		fromWork := fromWorks[workPath]
+		fromMembers := make(map[module.Version]replaceSource)
+
+		for _, modDir := range workspaces.ModuleDirs(workPath) {
+			modPath := filepath.Join(modDir, "go.mod")
+			data, err := os.ReadFile(modPath)
+			if err != nil {
+				continue // a use without go.mod is go's error to report
+			}
+			mf, err := modfile.Parse(modPath, data, nil)
+			if err != nil {
+				return err
+			}
+			changed := false
+			for _, r := range mf.Replace {
+				target := replaceTarget(modDir, r.New)
+				if ws, ok := fromWork[r.Old.Path]; ok {
+					w := workReplaceFor(ws, r.Old)
+					if w.target == target && (w.old == r.Old || w.old.Version == "") {
+						continue
+					}
+					shared := len(workTargets[modPath+"\x00"+r.Old.String()]) > 1
+					if resolve && !shared {
+						newV := w.new
+						if newV.Version == "" {
+							rel, err := filepath.Rel(modDir, w.target)
+							if err != nil {
+								return err
+							}
+							newV = module.Version{Path: dirPath(rel)}
+						}
+						setReplaceNew(r, newV)
+						changed = true
+						fmt.Printf("[work] %s: replace %s => %s (aligned with %s)\n",
+							modPath, r.Old, r.New, rep.Rel(workPath))
+						continue
+					}
+					rm.Addf("replace-conflict", "go.work", "%s: go.work replaces %s with %s, overriding the replace in %s (%s); the module alone (go mod tidy) does not see the go.work replace",
+						r.Old, w.old, rep.Rel(w.target), rep.Rel(modPath), rep.Rel(target))
+					if resolve && shared {
+						rm.Addf("replace-conflict", "go.work", "%s: not aligned, the workspaces using %s replace it with different targets",
+							r.Old, rep.Rel(modPath))
+					}
+					fmt.Fprintf(os.Stderr, "warning: %s: replace of %s overridden by %s\n", modPath, r.Old, workPath)
+					continue
+				}
+				if m, ok := fromMembers[r.Old]; ok {
+					if m.target != target {
+						rm.Addf("replace-conflict", "go.work", "%s: %s replaces it with %s but %s uses %s; add a replace to go.work to choose",
+							r.Old, rep.Rel(m.file), rep.Rel(m.target), rep.Rel(modPath), rep.Rel(target))
+						fmt.Fprintf(os.Stderr, "warning: %s: conflicting replacements for %s in workspace members\n", workPath, r.Old)
+					}
+					continue
+				}
+				fromMembers[r.Old] = replaceSource{modPath, r.Old, target, r.New}
+			}
+			if changed {
+				if err := os.WriteFile(modPath, modfile.Format(mf.Syntax), 0o644); err != nil {
+					return err
+				}
+			}
+		}
+
+		var effective []report.Replacement
+		for _, ws := range fromWork {
+			for _, s := range ws {
+				effective = append(effective, report.Replacement{Old: s.old.String(), New: rep.Rel(s.target), From: rep.Rel(s.file)})
+			}
+		}
+		for old, s := range fromMembers {
+			effective = append(effective, report.Replacement{Old: old.String(), New: rep.Rel(s.target), From: rep.Rel(s.file)})
+		}
+		sort.Slice(effective, func(i, j int) bool { return effective[i].Old < effective[j].Old })
+		rm.SetEffectiveReplaces(effective)
+	}
+	return nil
+}
+
+// replaceSource is one replace directive and the file declaring it.
+type replaceSource struct {
+	file   string
+	old    module.Version
+	target string         // see replaceTarget
+	new    module.Version // as written
+}
+
+// workReplaceFor picks, among the go.work replaces of old's path, the one
+// a member replace of old is compared with: the one for old's version, else
+// the one for all versions, else the first.
+func workReplaceFor(ws []replaceSource, old module.Version) replaceSource {
+	for _, w := range ws {
+		if w.old == old {
+			return w
+		}
+	}
+	for _, w := range ws {
+		if w.old.Version == "" {
+			return w
+		}
+	}
+	return ws[0]
+}
+
+// replaceTarget returns the target of a replace written in dir: the
+// absolute directory for a directory replace, path@version otherwise.
+func replaceTarget(dir string, v module.Version) string {
+	if v.Version != "" {
+		return v.String()
+	}
+	return localDir(dir, v.Path)
+}
+
+// localDir resolves a directory replace or use path written in dir.
+func localDir(dir string, p string) string {
+	p = filepath.FromSlash(p)
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p)
+	}
+	return filepath.Join(dir, p)
+}
+
+// setReplaceNew points a replace line at v, rewriting only the tokens after
+// "=>".
+func setReplaceNew(r *modfile.Replace, v module.Version) {
+	tok := r.Syntax.Token
+	for i, t := range tok {
+		if t == "=>" {
+			tok = append(tok[:i+1], modfile.AutoQuote(v.Path))
+			if v.Version != "" {
+				tok = append(tok, v.Version)
+			}
+			break
+		}
+	}
+	r.Syntax.Token = tok
+	r.New = v
+}
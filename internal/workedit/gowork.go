@@ -0,0 +1,136 @@
+package workedit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/util"
+
+	"golang.org/x/mod/modfile"
+)
+
+// FindWorkspace returns the go.work the go command would use for commands
+// run in dir, or "" for none. gowork is the GOWORK setting: "off" disables
+// workspaces, a path names the file directly (it must be absolute, as for
+// the go command), and "" or "auto" looks for go.work in dir and then in
+// each parent directory.
+func FindWorkspace(dir string, gowork string) (string, error) {
+	switch gowork {
+	case "off":
+		return "", nil
+	case "", "auto":
+	default:
+		if !filepath.IsAbs(gowork) {
+			return "", fmt.Errorf("invalid GOWORK %q: not an absolute path", gowork)
+		}
+		if _, err := os.Stat(gowork); err != nil {
+			return "", fmt.Errorf("invalid GOWORK: %w", err)
+		}
+		return filepath.Clean(gowork), nil
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		p := filepath.Join(dir, "go.work")
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			return p, nil
+		} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// UsesWithin reports whether a use directive of workPath names dir or a
+// directory inside it. A go.work above dir that uses none of its modules
+// has nothing to say about how they build.
+func UsesWithin(workPath string, dir string) (bool, error) {
+	data, err := os.ReadFile(workPath)
+	if err != nil {
+		return false, err
+	}
+	wf, err := modfile.ParseWork(workPath, data, nil)
+	if err != nil {
+		return false, err
+	}
+	for _, u := range wf.Use {
+		if util.IsWithin(localDir(filepath.Dir(workPath), u.Path), dir) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ImportWorkspace writes workPath, a go.work that is not part of the
+// scanned tree, as go.work in copiedRoot. Its relative use and directory
+// replace paths are re-expressed from originalRoot, so RewriteGoWorkFiles
+// then handles the file like one written there: members inside the tree
+// point at their copies, the others are copied under the external base.
+// e remembers where the file came from, for ValidateRewrites.
+func (e *ExternalCopies) ImportWorkspace(workPath string, originalRoot string, copiedRoot string) (string, error) {
+	data, err := os.ReadFile(workPath)
+	if err != nil {
+		return "", err
+	}
+	wf, err := modfile.ParseWork(workPath, data, nil)
+	if err != nil {
+		return "", err
+	}
+
+	workDir := filepath.Dir(workPath)
+	fromRoot := func(p string) (string, error) {
+		if filepath.IsAbs(filepath.FromSlash(p)) {
+			return p, nil
+		}
+		rel, err := filepath.Rel(originalRoot, localDir(workDir, p))
+		if err != nil {
+			return "", err
+		}
+		return dirPath(rel), nil
+	}
+	for _, u := range wf.Use {
+		p, err := fromRoot(u.Path)
+		if err != nil {
+			return "", err
+		}
+		if p != u.Path {
+			setLastToken(u.Syntax, p)
+			u.Path = p
+		}
+	}
+	for _, r := range wf.Replace {
+		if r.New.Version != "" {
+			continue
+		}
+		p, err := fromRoot(r.New.Path)
+		if err != nil {
+			return "", err
+		}
+		if p != r.New.Path {
+			setLastToken(r.Syntax, p)
+			r.New.Path = p
+		}
+	}
+
+	dest := filepath.Join(copiedRoot, "go.work")
+	if err := os.WriteFile(dest, modfile.Format(wf.Syntax), 0o644); err != nil {
+		return "", err
+	}
+	if sum, err := os.ReadFile(workPath + ".sum"); err == nil {
+		if err := os.WriteFile(dest+".sum", sum, 0o644); err != nil {
+			return "", err
+		}
+	}
+	e.imported[dest] = workPath
+	return dest, nil
+}
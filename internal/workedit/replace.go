@@ -0,0 +1,179 @@
+package workedit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/report"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// ReplacePolicy says what happens to replace directives whose target is a
+// module version (a version pin or a fork) rather than a directory.
+type ReplacePolicy string
+
+const (
+	// ReplaceKeep leaves them as written.
+	ReplaceKeep ReplacePolicy = "keep"
+	// ReplaceFlagForks leaves them as written and records an issue for each
+	// one that points at a different module path.
+	ReplaceFlagForks ReplacePolicy = "flag-forks"
+	// ReplaceLocalize copies the target from the module cache under the
+	// external base and points the replace at the copy, so packaging needs
+	// no download for it.
+	ReplaceLocalize ReplacePolicy = "localize"
+)
+
+// ParseReplacePolicy parses a policy name as accepted on the command line.
+func ParseReplacePolicy(s string) (ReplacePolicy, error) {
+	switch p := ReplacePolicy(s); p {
+	case ReplaceKeep, ReplaceFlagForks, ReplaceLocalize:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown replace policy %q (want keep, flag-forks or localize)", s)
+}
+
+// RewriteVersionedReplaces applies policy to the versioned replaces in the
+// copied go.work and go.mod files and records every one of them in rep.
+// moduleCache is the GOMODCACHE to localize from; a target missing there is
+// kept as written and reported.
+func RewriteVersionedReplaces(workFiles []string, modFiles []string, policy ReplacePolicy, moduleCache string, externalBase string, externals *ExternalCopies, rep *report.Report) error {
+	for _, workPath := range workFiles {
+		data, err := os.ReadFile(workPath)
+		if err != nil {
+			return err
+		}
+		wf, err := modfile.ParseWork(workPath, data, nil)
+		if err != nil {
+			return err
+		}
+		changed, err := applyReplacePolicy(workPath, report.KindWorkspace, wf.Replace, policy, moduleCache, externalBase, externals, rep)
+		if err != nil {
+			return err
+		}
+		if changed {
+			if err := os.WriteFile(workPath, modfile.Format(wf.Syntax), 0o644); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, modPath := range modFiles {
+		data, err := os.ReadFile(modPath)
+		if err != nil {
+			return err
+		}
+		mf, err := modfile.Parse(modPath, data, nil)
+		if err != nil {
+			return err
+		}
+		changed, err := applyReplacePolicy(modPath, report.KindModule, mf.Replace, policy, moduleCache, externalBase, externals, rep)
+		if err != nil {
+			return err
+		}
+		if changed {
+			if err := os.WriteFile(modPath, modfile.Format(mf.Syntax), 0o644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyReplacePolicy handles the versioned replaces of one file, editing
+// their lines in place. It reports whether any line changed.
+func applyReplacePolicy(file string, kind string, replaces []*modfile.Replace, policy ReplacePolicy, moduleCache string, externalBase string, externals *ExternalCopies, rep *report.Report) (bool, error) {
+	changed := false
+	for _, r := range replaces {
+		if r.New.Version == "" {
+			continue // directory replace, handled by the path rewrite
+		}
+		rm := rep.Module(filepath.Dir(file), kind)
+		rec := report.Replace{
+			File:   filepath.Base(file),
+			Line:   r.Syntax.Start.Line,
+			Old:    r.Old.String(),
+			New:    r.New.String(),
+			Fork:   r.New.Path != r.Old.Path,
+			Action: "kept",
+		}
+
+		switch policy {
+		case ReplaceFlagForks:
+			if rec.Fork {
+				rec.Action = "flagged"
+				rm.Addf("replace", rec.File, "%s is replaced by fork %s (line %d)", rec.Old, rec.New, rec.Line)
+				fmt.Fprintf(os.Stderr, "warning: %s: %s is replaced by fork %s\n", file, rec.Old, rec.New)
+			}
+		case ReplaceLocalize:
+			cached, err := cachedModuleDir(moduleCache, r.New)
+			if err != nil {
+				return false, err
+			}
+			if cached == "" {
+				rm.Addf("replace", rec.File, "cannot localize %s: %s is not in the module cache %s", rec.Old, rec.New, moduleCache)
+				fmt.Fprintf(os.Stderr, "warning: %s: %s not in module cache, replace kept\n", file, rec.New)
+				break
+			}
+			// A directory replace only matches the old path, so the target's
+			// go.mod must declare it; a versioned replace also accepts a fork
+			// declaring its own path, or a version without go.mod.
+			if why := localizeBlocker(cached, r.Old.Path); why != "" {
+				rm.Addf("replace", rec.File, "cannot localize %s: %s %s; replace kept", rec.Old, rec.New, why)
+				fmt.Fprintf(os.Stderr, "warning: %s: %s %s, replace kept\n", file, rec.New, why)
+				break
+			}
+			destDir, _, err := externals.copyModule(cached, externalBase)
+			if err != nil {
+				return false, err
+			}
+			relFromFileToDest, err := filepath.Rel(filepath.Dir(file), destDir)
+			if err != nil {
+				return false, err
+			}
+			final := dirPath(relFromFileToDest)
+			setReplaceNew(r, module.Version{Path: final})
+			rec.Action = "localized"
+			rec.Local = rep.Rel(destDir)
+			changed = true
+		}
+
+		fmt.Printf("[rplc] %s: %s => %s (%s)\n", file, rec.Old, rec.New, rec.Action)
+		rm.AddReplace(rec)
+	}
+	return changed, nil
+}
+
+// localizeBlocker says why the module extracted in dir cannot stand in for
+// oldPath as a directory replace, or returns "".
+func localizeBlocker(dir string, oldPath string) string {
+	data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return "has no go.mod"
+	}
+	if modPath := modfile.ModulePath(data); modPath != oldPath {
+		return "declares module " + modPath
+	}
+	return ""
+}
+
+// cachedModuleDir returns the extracted directory of m in moduleCache, or
+// "" if it is not there.
+func cachedModuleDir(moduleCache string, m module.Version) (string, error) {
+	escPath, err := module.EscapePath(m.Path)
+	if err != nil {
+		return "", err
+	}
+	escVersion, err := module.EscapeVersion(m.Version)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(moduleCache, filepath.FromSlash(escPath)+"@"+escVersion)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", nil
+	}
+	return dir, nil
+}
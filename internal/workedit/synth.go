@@ -0,0 +1,104 @@
+package workedit
+
+import (
+	"fmt"
+	"go/version"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/report"
+
+	"golang.org/x/mod/modfile"
+)
+
+// MinWorkVendorGo is the first go version with "go work vendor".
+const MinWorkVendorGo = "1.22"
+
+// SynthesizeGoWork writes a go.work in copiedRoot that uses every module in
+// modFiles, so they are vendored together by one "go work vendor" instead
+// of each on its own. Modules under testdata or vendor directories, or under
+// directories starting with "." or "_", are left out, as the go command
+// ignores them for packages. A module whose path is already used (a copied
+// example, say) is left out and reported in rep, since a workspace cannot
+// hold a module twice. The go version is the highest required by a module,
+// and at least MinWorkVendorGo. It returns the path of the go.work and the
+// number of modules used, or "" if fewer than two are left.
+func SynthesizeGoWork(copiedRoot string, modFiles []string, rep *report.Report) (string, int, error) {
+	goVersion := MinWorkVendorGo
+	var dirs []string
+	byModule := make(map[string]string) // module path -> first use
+	// Shallowest first, so a module keeps its main copy over nested examples
+	modFiles = slices.Clone(modFiles)
+	sort.SliceStable(modFiles, func(i, j int) bool {
+		return strings.Count(modFiles[i], string(filepath.Separator)) < strings.Count(modFiles[j], string(filepath.Separator))
+	})
+	for _, modPath := range modFiles {
+		rel, err := filepath.Rel(copiedRoot, filepath.Dir(modPath))
+		if err != nil {
+			return "", 0, err
+		}
+		if ignoredDir(rel) {
+			continue
+		}
+		data, err := os.ReadFile(modPath)
+		if err != nil {
+			return "", 0, err
+		}
+		mf, err := modfile.ParseLax(modPath, data, nil)
+		if err != nil {
+			return "", 0, err
+		}
+		if mf.Module != nil {
+			modulePath := mf.Module.Mod.Path
+			if first, dup := byModule[modulePath]; dup {
+				rep.Addf("synthesize", modPath, "module %s already used from %s, left out of the generated go.work", modulePath, first)
+				fmt.Fprintf(os.Stderr, "warning: %s: module %s is also in %s, not used in the generated go.work\n", modPath, modulePath, first)
+				continue
+			}
+			byModule[modulePath] = dirPath(rel)
+		}
+		if mf.Go != nil && version.Compare("go"+mf.Go.Version, "go"+goVersion) > 0 {
+			goVersion = mf.Go.Version
+		}
+		dirs = append(dirs, dirPath(rel))
+	}
+	if len(dirs) < 2 {
+		return "", len(dirs), nil
+	}
+
+	wf := &modfile.WorkFile{Syntax: &modfile.FileSyntax{}}
+	if err := wf.AddGoStmt(goVersion); err != nil {
+		return "", 0, err
+	}
+	for _, d := range dirs {
+		if err := wf.AddUse(d, ""); err != nil {
+			return "", 0, err
+		}
+	}
+	wf.SortBlocks()
+	wf.Cleanup()
+
+	workPath := filepath.Join(copiedRoot, "go.work")
+	if _, err := os.Stat(workPath); err == nil {
+		return "", 0, fmt.Errorf("%s already exists", workPath)
+	}
+	if err := os.WriteFile(workPath, modfile.Format(wf.Syntax), 0o644); err != nil {
+		return "", 0, err
+	}
+	return workPath, len(dirs), nil
+}
+
+// ignoredDir reports whether the go command ignores the slash or
+// OS-separated relative directory rel when matching packages.
+func ignoredDir(rel string) bool {
+	for _, elem := range strings.Split(filepath.ToSlash(rel), "/") {
+		if elem == "testdata" || elem == "vendor" ||
+			(elem != "." && (strings.HasPrefix(elem, ".") || strings.HasPrefix(elem, "_"))) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,255 @@
+package workedit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/relaxnow/vc-gowork-poc/internal/report"
+	"github.com/relaxnow/vc-gowork-poc/internal/util"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+const stageRewriteCheck = "rewrite-check"
+
+// ValidateRewrites checks that the use and directory replace directives of
+// the rewritten go.work files, the go.mod files in modFiles (including
+// those inside external copies) and the go.mod of every workspace member
+// lead somewhere the go command accepts:
+//   - a use names an existing directory with a go.mod
+//   - no two uses of one go.work name the same module path
+//   - a directory replace names a directory whose go.mod declares the
+//     replaced module path
+//
+// The copied files are checked as the go command will read them, after
+// every edit (path rewrite, localized and aligned replaces). Problems are
+// recorded in rep and printed with the original file and line, which is
+// where they have to be fixed.
+func ValidateRewrites(originalRoot, copiedRoot string, workspaces Workspaces, modFiles []string, externals *ExternalCopies, rep *report.Report) error {
+	workFiles := make([]string, 0, len(workspaces))
+	for w := range workspaces {
+		workFiles = append(workFiles, w)
+	}
+	sort.Strings(workFiles)
+
+	seen := make(map[string]bool)
+	var goMods []string
+	for _, m := range modFiles {
+		seen[filepath.Clean(m)] = true
+		goMods = append(goMods, m)
+	}
+	for _, w := range workFiles {
+		for _, d := range workspaces.ModuleDirs(w) {
+			if m := filepath.Join(d, "go.mod"); !seen[m] && fileExists(m) {
+				seen[m] = true
+				goMods = append(goMods, m)
+			}
+		}
+	}
+
+	v := &validator{originalRoot: originalRoot, copiedRoot: copiedRoot, externals: externals, rep: rep}
+	for _, w := range workFiles {
+		if err := v.checkFile(w, report.KindWorkspace); err != nil {
+			return err
+		}
+	}
+	for _, m := range goMods {
+		if err := v.checkFile(m, report.KindModule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type validator struct {
+	originalRoot, copiedRoot string
+	externals                *ExternalCopies
+	rep                      *report.Report
+}
+
+// checkFile validates the directives of the copied file copied, which is
+// what the go command will read, after every rewrite. The original file is
+// only used to name the file and line to fix, see locate.
+func (v *validator) checkFile(copied string, kind string) error {
+	data, err := os.ReadFile(copied)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(copied)
+	loc, err := v.locate(copied, kind)
+	if err != nil {
+		return err
+	}
+
+	rm := v.rep.Module(dir, kind)
+	problem := func(file string, line int, format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		rm.Addf(stageRewriteCheck, filepath.Base(copied), "line %d: %s", line, msg)
+		fmt.Fprintf(os.Stderr, "warning: %s:%d: %s\n", file, line, msg)
+	}
+
+	var replaces []*modfile.Replace
+	if kind == report.KindWorkspace {
+		wf, err := modfile.ParseWork(copied, data, nil)
+		if err != nil {
+			return err
+		}
+		type firstUse struct {
+			line int
+			path string
+		}
+		byModule := make(map[string]firstUse)
+		for i, u := range wf.Use {
+			target := localDir(dir, u.Path)
+			file, line := loc.use(i, len(wf.Use), target, u.Syntax.Start.Line)
+			if info, err := os.Stat(target); err != nil || !info.IsDir() {
+				problem(file, line, "use %s: directory does not exist", u.Path)
+				continue
+			}
+			modData, err := os.ReadFile(filepath.Join(target, "go.mod"))
+			if err != nil {
+				problem(file, line, "use %s: directory has no go.mod", u.Path)
+				continue
+			}
+			modPath := modfile.ModulePath(modData)
+			if prev, dup := byModule[modPath]; dup && modPath != "" {
+				problem(file, line, "use %s: module %s is already used by line %d (%s)", u.Path, modPath, prev.line, prev.path)
+				continue
+			}
+			byModule[modPath] = firstUse{line, u.Path}
+		}
+		replaces = wf.Replace
+	} else {
+		mf, err := modfile.Parse(copied, data, nil)
+		if err != nil {
+			return err
+		}
+		replaces = mf.Replace
+	}
+
+	for _, r := range replaces {
+		if r.New.Version != "" {
+			continue
+		}
+		target := localDir(dir, r.New.Path)
+		file, line := loc.replace(r.Old, r.Syntax.Start.Line)
+		if info, err := os.Stat(target); err != nil || !info.IsDir() {
+			problem(file, line, "replace %s => %s: directory does not exist", r.Old, r.New.Path)
+			continue
+		}
+		modData, err := os.ReadFile(filepath.Join(target, "go.mod"))
+		if err != nil {
+			problem(file, line, "replace %s => %s: directory has no go.mod", r.Old, r.New.Path)
+			continue
+		}
+		if modPath := modfile.ModulePath(modData); modPath != r.Old.Path {
+			problem(file, line, "replace %s => %s: go.mod there declares module %s", r.Old, r.New.Path, modPath)
+		}
+	}
+	return nil
+}
+
+// locator maps the directives of a copied file back to the original file
+// and line. Replaces are matched by their old module (unique in a file),
+// uses by the package directory the original path maps to, or by position
+// when the rewrite kept every use.
+type locator struct {
+	file     string
+	copied   string
+	uses     map[string]int // package directory of an original use -> line
+	useLines []int          // original use lines in order
+	replaces map[module.Version]int
+}
+
+func (l *locator) use(i, n int, target string, copiedLine int) (string, int) {
+	if line, ok := l.uses[filepath.Clean(target)]; ok {
+		return l.file, line
+	}
+	if len(l.useLines) == n {
+		return l.file, l.useLines[i]
+	}
+	return l.copied, copiedLine
+}
+
+func (l *locator) replace(old module.Version, copiedLine int) (string, int) {
+	if line, ok := l.replaces[old]; ok {
+		return l.file, line
+	}
+	return l.copied, copiedLine
+}
+
+// locate parses the original of copied: the file ImportWorkspace brought in,
+// or the same file in the original tree or external directory. Files
+// written during packaging have no original and map to themselves.
+func (v *validator) locate(copied string, kind string) (*locator, error) {
+	loc := &locator{copied: copied, file: copied}
+	origFile, ok := v.externals.imported[copied]
+	if !ok {
+		origDir, err := v.externals.originalDir(filepath.Dir(copied), v.originalRoot, v.copiedRoot)
+		if err != nil {
+			return nil, err
+		}
+		origFile = filepath.Join(origDir, filepath.Base(copied))
+	}
+	data, err := os.ReadFile(origFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return loc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	loc.file = origFile
+	loc.replaces = make(map[module.Version]int)
+	origDir := filepath.Dir(origFile)
+	var replaces []*modfile.Replace
+	if kind == report.KindWorkspace {
+		wf, err := modfile.ParseWork(origFile, data, nil)
+		if err != nil {
+			return nil, err
+		}
+		loc.uses = make(map[string]int)
+		for _, u := range wf.Use {
+			loc.useLines = append(loc.useLines, u.Syntax.Start.Line)
+			if dir, ok := v.packageDir(localDir(origDir, u.Path)); ok {
+				if _, dup := loc.uses[dir]; !dup {
+					loc.uses[dir] = u.Syntax.Start.Line
+				}
+			}
+		}
+		replaces = wf.Replace
+	} else {
+		mf, err := modfile.Parse(origFile, data, nil)
+		if err != nil {
+			return nil, err
+		}
+		replaces = mf.Replace
+	}
+	for _, r := range replaces {
+		loc.replaces[r.Old] = r.Syntax.Start.Line
+	}
+	return loc, nil
+}
+
+// packageDir returns where the original directory origAbs was copied to in
+// the package, if it was.
+func (v *validator) packageDir(origAbs string) (string, bool) {
+	if util.IsWithin(origAbs, v.originalRoot) {
+		rel, err := filepath.Rel(v.originalRoot, origAbs)
+		if err != nil {
+			return "", false
+		}
+		return filepath.Join(v.copiedRoot, rel), true
+	}
+	return v.externals.lookup(origAbs)
+}
+
+func fileExists(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && !info.IsDir()
+}
@@ -1,6 +1,10 @@
 package workedit
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -13,19 +17,246 @@ import (
 	"golang.org/x/mod/modfile"
 )
 
-// ReplaceEdit describes one replace directive to add.
-type ReplaceEdit struct {
-	oldPath, oldVersion string
-	newPath, newVersion string
+// MappingFile is written in the external base by WriteMapping.
+const MappingFile = "mapping.json"
+
+// ExternalCopies is the registry of directories copied under the external
+// base. Each original directory is copied once, however many go.work and
+// go.mod files reference it.
+type ExternalCopies struct {
+	originalRoot string            // canonical scanned directory
+	origins      map[string]string // copied dir -> original dir
+	copies       map[string]string // canonical original dir -> copied dir
+	modules      map[string]string // copied dir -> module path of its go.mod
+	pending      []string          // copies whose go.mod files are not rewritten yet
+	imported     map[string]string // go.work written by ImportWorkspace -> its original
+}
+
+// MappingEntry is one copy listed in the mapping file.
+type MappingEntry struct {
+	Dir         string `json:"dir"`              // name under the external base
+	Original    string `json:"original"`         // absolute original directory
+	OriginalRel string `json:"originalRel"`      // relative to the scanned directory, slash separated
+	Module      string `json:"module,omitempty"` // module path from the copy's go.mod
+}
+
+// NewExternalCopies returns an empty registry for copies of directories
+// outside originalRoot.
+func NewExternalCopies(originalRoot string) *ExternalCopies {
+	return &ExternalCopies{
+		originalRoot: canonicalDir(originalRoot),
+		origins:      make(map[string]string),
+		copies:       make(map[string]string),
+		modules:      make(map[string]string),
+		imported:     make(map[string]string),
+	}
+}
+
+// Origins returns a copy of the copied dir -> original dir mapping.
+func (e *ExternalCopies) Origins() map[string]string {
+	out := make(map[string]string, len(e.origins))
+	for dest, orig := range e.origins {
+		out[dest] = orig
+	}
+	return out
+}
+
+// copyExternal returns the copy of origAbs under externalBase, copying it on
+// first use; fresh reports whether it did. Copies are keyed by the canonical
+// original path, so references through different relative paths or
+// symlinks share one copy, and a directory inside an existing copy resolves
+// into it.
+func (e *ExternalCopies) copyExternal(origAbs string, externalBase string) (destDir string, fresh bool, err error) {
+	return e.copyDir(origAbs, externalBase, true)
+}
+
+// copyModule is copyExternal for a module extracted in the module cache.
+// The copy is not queued for RewriteExternalCopies: replaces in a published
+// module's go.mod are never applied and may name directories that only
+// existed on its author's machine.
+func (e *ExternalCopies) copyModule(dir string, externalBase string) (destDir string, fresh bool, err error) {
+	return e.copyDir(dir, externalBase, false)
+}
+
+func (e *ExternalCopies) copyDir(origAbs string, externalBase string, rewrite bool) (destDir string, fresh bool, err error) {
+	if dest, ok := e.lookup(origAbs); ok {
+		return dest, false, nil
+	}
+	if info, err := os.Stat(origAbs); err != nil || !info.IsDir() {
+		return "", false, fmt.Errorf("%s: %w", origAbs, errNotDir)
+	}
+
+	key := canonicalDir(origAbs)
+	name, modPath := e.externalName(key)
+	destDir = filepath.Join(externalBase, name)
+	if err := copytree.CopyTreeNormalized(origAbs, destDir); err != nil {
+		return "", false, err
+	}
+	e.origins[filepath.Clean(destDir)] = filepath.Clean(origAbs)
+	e.modules[filepath.Clean(destDir)] = modPath
+	e.copies[key] = destDir
+	if rewrite {
+		e.pending = append(e.pending, destDir)
+	}
+	return destDir, true, nil
+}
+
+// errNotDir is returned by copyExternal for a path that is not an existing
+// directory. The directive naming it is left as written.
+var errNotDir = errors.New("not an existing directory")
+
+// lookup returns where origAbs is found among the copies made so far: the
+// copy of the directory itself, or the matching directory inside the copy
+// of an enclosing one.
+func (e *ExternalCopies) lookup(origAbs string) (string, bool) {
+	key := canonicalDir(origAbs)
+	if dest, ok := e.copies[key]; ok {
+		return dest, true
+	}
+	enclosing := ""
+	for orig := range e.copies {
+		if util.IsWithin(key, orig) && len(orig) > len(enclosing) {
+			enclosing = orig
+		}
+	}
+	if enclosing == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(enclosing, key)
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(e.copies[enclosing], rel), true
+}
+
+// externalName returns the directory name for the copy of the canonical
+// directory key, and the module path it was derived from. The name is the
+// module path of key's go.mod with slashes replaced (the base name if there
+// is none) plus a short hash of key relative to the scanned directory: the
+// same tree always gets the same names, and different directories never
+// share one.
+func (e *ExternalCopies) externalName(key string) (name string, modPath string) {
+	name = filepath.Base(key)
+	if data, err := os.ReadFile(filepath.Join(key, "go.mod")); err == nil {
+		if modPath = modfile.ModulePath(data); modPath != "" {
+			name = strings.ReplaceAll(modPath, "/", "_")
+		}
+	}
+	sum := sha256.Sum256([]byte(e.relToRoot(key)))
+	return name + "-" + hex.EncodeToString(sum[:4]), modPath
+}
+
+// relToRoot returns dir relative to the scanned directory, slash separated.
+func (e *ExternalCopies) relToRoot(dir string) string {
+	rel, err := filepath.Rel(e.originalRoot, dir)
+	if err != nil {
+		return filepath.ToSlash(dir)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// WriteMapping writes MappingFile in externalBase, listing where each copy
+// came from. Nothing is written if there are no copies.
+func (e *ExternalCopies) WriteMapping(externalBase string) error {
+	if len(e.origins) == 0 {
+		return nil
+	}
+	entries := make([]MappingEntry, 0, len(e.origins))
+	for dest, orig := range e.origins {
+		entries = append(entries, MappingEntry{
+			Dir:         filepath.Base(dest),
+			Original:    orig,
+			OriginalRel: e.relToRoot(canonicalDir(orig)),
+			Module:      e.modules[dest],
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Dir < entries[j].Dir })
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(externalBase, MappingFile), append(data, '\n'), 0o644)
+}
+
+// canonicalDir returns dir absolute, cleaned and with symlinks resolved, or
+// just cleaned if it cannot be resolved.
+func canonicalDir(dir string) string {
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return filepath.Clean(dir)
+}
+
+// originalDir returns the directory dir, inside the copied tree, was copied
+// from. Relative paths in files under dir were written for that location.
+func (e *ExternalCopies) originalDir(dir string, originalRoot string, copiedRoot string) (string, error) {
+	for dest, orig := range e.origins {
+		if util.IsWithin(dir, dest) {
+			rel, err := filepath.Rel(dest, dir)
+			if err != nil {
+				return "", err
+			}
+			return filepath.Join(orig, rel), nil
+		}
+	}
+	rel, err := filepath.Rel(copiedRoot, dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(originalRoot, rel), nil
+}
+
+// Workspaces maps each rewritten go.work path to the set of module
+// directories its use directives reference.
+type Workspaces map[string]map[string]struct{}
+
+// ModuleDirs returns the module directories used by workPath, sorted.
+func (w Workspaces) ModuleDirs(workPath string) []string {
+	dirs := make([]string, 0, len(w[workPath]))
+	for d := range w[workPath] {
+		dirs = append(dirs, d)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// AllModuleDirs returns the module directories used by any workspace.
+func (w Workspaces) AllModuleDirs() map[string]struct{} {
+	all := make(map[string]struct{})
+	for _, dirs := range w {
+		for d := range dirs {
+			all[d] = struct{}{}
+		}
+	}
+	return all
+}
+
+// IsMember reports whether modDir is itself used by some go.work. A module
+// nested inside a member is a separate module and does not count.
+func (w Workspaces) IsMember(modDir string) bool {
+	modDir = filepath.Clean(modDir)
+	for _, dirs := range w {
+		if _, ok := dirs[modDir]; ok {
+			return true
+		}
+	}
+	return false
 }
 
-// RewriteGoWorkFiles updates use and path-based replace entries IN PLACE.
-// External paths are copied under externalBase. It returns a set of directories
-// referenced by use directives after rewrite.
-func RewriteGoWorkFiles(originalRoot, copiedRoot string, workFiles []string, externalBase string) (map[string]struct{}, error) {
-	usedModuleDirs := make(map[string]struct{})
+// RewriteGoWorkFiles updates use and path-based replace entries IN PLACE:
+// only the path token of a line that has to change is replaced, so
+// comments, ordering and blocks are kept. External paths are copied under
+// externalBase and recorded in externals. It returns, per go.work, the
+// directories referenced by use directives after rewrite.
+func RewriteGoWorkFiles(originalRoot, copiedRoot string, workFiles []string, externalBase string, externals *ExternalCopies) (Workspaces, error) {
+	workspaces := make(Workspaces, len(workFiles))
 
 	for _, workPathCopied := range workFiles {
+		usedModuleDirs := make(map[string]struct{})
+		workspaces[workPathCopied] = usedModuleDirs
 		workDirCopied := filepath.Dir(workPathCopied)
 		relFromCopiedRoot, err := filepath.Rel(copiedRoot, workDirCopied)
 		if err != nil {
@@ -38,63 +269,65 @@ func RewriteGoWorkFiles(originalRoot, copiedRoot string, workFiles []string, ext
 			return nil, err
 		}
 
-		wf, err := modfile.ParseWork("go.work", data, nil)
+		wf, err := modfile.ParseWork(workPathCopied, data, nil)
 		if err != nil {
 			return nil, err
 		}
 
-		// Build desired new state without mutating wf.Use or wf.Replace yet.
-		var desiredUsePaths []string
-		var desiredReplaces []ReplaceEdit
-
-		// Compute desired USE entries
+		changed := false
 		for _, u := range wf.Use {
 			origUseAbs := u.Path
 			if !filepath.IsAbs(origUseAbs) {
 				origUseAbs = filepath.Clean(filepath.Join(workDirOriginal, u.Path))
 			}
+			var targetAbs string
 			if util.IsWithin(origUseAbs, originalRoot) {
 				relFromOriginalRoot, err := filepath.Rel(originalRoot, origUseAbs)
 				if err != nil {
 					return nil, err
 				}
-				copiedAbs := filepath.Join(copiedRoot, relFromOriginalRoot)
-				relFromWorkToCopied, err := filepath.Rel(workDirCopied, copiedAbs)
-				if err != nil {
-					return nil, err
-				}
-				final := filepath.ToSlash(relFromWorkToCopied)
-
-				origToken := filepath.ToSlash(u.Path)
-				if !filepath.IsAbs(u.Path) && (origToken == final || origToken == ".") {
-					desiredUsePaths = append(desiredUsePaths, origToken)
-				} else {
-					fmt.Printf("[work] %s: use %q -> %q (normalize inside tree)\n",
-						workPathCopied, u.Path, final)
-					desiredUsePaths = append(desiredUsePaths, final)
-				}
-				usedModuleDirs[filepath.Clean(copiedAbs)] = struct{}{}
+				targetAbs = filepath.Join(copiedRoot, relFromOriginalRoot)
 			} else {
-				base := filepath.Base(origUseAbs)
-				destDir := util.UniqueDir(filepath.Join(externalBase, base))
-				fmt.Printf("[work] %s: use %q external -> copying to %s\n",
-					workPathCopied, u.Path, destDir)
-				if err := copytree.CopyTreeNormalized(origUseAbs, destDir); err != nil {
-					return nil, err
+				destDir, fresh, err := externals.copyExternal(origUseAbs, externalBase)
+				if errors.Is(err, errNotDir) {
+					fmt.Printf("[work] %s: use %q external -> does not exist, left as is\n", workPathCopied, u.Path)
+					continue
 				}
-				relFromWorkToDest, err := filepath.Rel(workDirCopied, destDir)
 				if err != nil {
 					return nil, err
 				}
-				final := filepath.ToSlash(relFromWorkToDest)
-				fmt.Printf("[work] %s: use %q -> %q (copied external)\n",
-					workPathCopied, u.Path, final)
-				desiredUsePaths = append(desiredUsePaths, final)
-				usedModuleDirs[filepath.Clean(destDir)] = struct{}{}
+				fmt.Printf("[work] %s: use %q external -> %s %s\n",
+					workPathCopied, u.Path, copiedOrReused(fresh), destDir)
+				targetAbs = destDir
+			}
+			targetAbs = filepath.Clean(targetAbs)
+
+			if _, dup := usedModuleDirs[targetAbs]; dup {
+				// Two uses now name the same directory, which go rejects.
+				fmt.Printf("[work] %s: use %q dropped (same directory as an earlier use)\n",
+					workPathCopied, u.Path)
+				u.Syntax.Token = nil
+				u.Path = ""
+				changed = true
+				continue
 			}
+			usedModuleDirs[targetAbs] = struct{}{}
+
+			if resolvesTo(workDirCopied, u.Path, targetAbs) {
+				continue
+			}
+			relFromWorkToTarget, err := filepath.Rel(workDirCopied, targetAbs)
+			if err != nil {
+				return nil, err
+			}
+			final := dirPath(relFromWorkToTarget)
+			fmt.Printf("[work] %s: use %q -> %q (final path in go.work)\n",
+				workPathCopied, u.Path, final)
+			setLastToken(u.Syntax, final)
+			u.Path = final
+			changed = true
 		}
 
-		// Compute desired REPLACE entries (versionless, path-based)
 		for _, r := range wf.Replace {
 			if r.New.Version != "" || r.New.Path == "" {
 				continue
@@ -111,174 +344,190 @@ func RewriteGoWorkFiles(originalRoot, copiedRoot string, workFiles []string, ext
 					return nil, err
 				}
 				targetAbs = filepath.Join(copiedRoot, relFromOriginalRoot)
-				fmt.Printf("[work] %s: replace %q => %q (inside source tree)\n",
-					workPathCopied, r.New.Path, targetAbs)
 			} else {
-				base := filepath.Base(origNewAbs)
-				destDir := util.UniqueDir(filepath.Join(externalBase, base))
-				fmt.Printf("[work] %s: replace %q external -> copying to %s\n",
-					workPathCopied, r.New.Path, destDir)
-				if err := copytree.CopyTreeNormalized(origNewAbs, destDir); err != nil {
+				destDir, fresh, err := externals.copyExternal(origNewAbs, externalBase)
+				if errors.Is(err, errNotDir) {
+					fmt.Printf("[work] %s: replace %q external -> does not exist, left as is\n", workPathCopied, r.New.Path)
+					continue
+				}
+				if err != nil {
 					return nil, err
 				}
+				fmt.Printf("[work] %s: replace %q external -> %s %s\n",
+					workPathCopied, r.New.Path, copiedOrReused(fresh), destDir)
 				targetAbs = destDir
 			}
 
+			if resolvesTo(workDirCopied, r.New.Path, targetAbs) {
+				continue
+			}
 			relFromWorkToTarget, err := filepath.Rel(workDirCopied, targetAbs)
 			if err != nil {
 				return nil, err
 			}
-			finalRel := filepath.ToSlash(relFromWorkToTarget)
+			finalRel := dirPath(relFromWorkToTarget)
 			fmt.Printf("[work] %s: replace %q => %q (final path in go.work)\n",
 				workPathCopied, r.New.Path, finalRel)
-
-			desiredReplaces = append(desiredReplaces, ReplaceEdit{
-				oldPath: r.Old.Path, oldVersion: r.Old.Version,
-				newPath: finalRel, newVersion: "",
-			})
-			// Do not mutate r.New.Path here.
+			setLastToken(r.Syntax, finalRel)
+			r.New.Path = finalRel
+			changed = true
 		}
 
-		// Apply edits in place and write back
-		outBytes := renderGoWorkInPlace(wf, desiredUsePaths, desiredReplaces)
-		if err := os.WriteFile(workPathCopied, outBytes, 0o644); err != nil {
+		if !changed {
+			continue
+		}
+		wf.Cleanup()
+		if err := os.WriteFile(workPathCopied, modfile.Format(wf.Syntax), 0o644); err != nil {
 			return nil, err
 		}
 	}
 
-	return usedModuleDirs, nil
+	return workspaces, nil
 }
 
 // RewriteGoModFiles updates path-based replaces in go.mod files.
-func RewriteGoModFiles(originalRoot, copiedRoot string, modFiles []string, externalBase string) error {
+// External paths are copied under externalBase and recorded in externals.
+func RewriteGoModFiles(originalRoot, copiedRoot string, modFiles []string, externalBase string, externals *ExternalCopies) error {
 	for _, modPathCopied := range modFiles {
-		modDirCopied := filepath.Dir(modPathCopied)
-		relFromCopiedRoot, err := filepath.Rel(copiedRoot, modDirCopied)
-		if err != nil {
+		if err := rewriteGoModFile(originalRoot, copiedRoot, modPathCopied, externalBase, externals); err != nil {
 			return err
 		}
-		modDirOriginal := filepath.Join(originalRoot, relFromCopiedRoot)
+	}
+	return nil
+}
+
+// RewriteExternalCopies rewrites the go.mod files inside external copies
+// made so far, which may copy further externals, until every copy has been
+// processed. Relative paths are resolved from each copy's original
+// location. Since each directory is copied only once, replace cycles
+// between external modules terminate.
+func RewriteExternalCopies(originalRoot, copiedRoot string, externalBase string, externals *ExternalCopies) error {
+	for len(externals.pending) > 0 {
+		destDir := externals.pending[0]
+		externals.pending = externals.pending[1:]
 
-		data, err := os.ReadFile(modPathCopied)
+		_, modFiles, err := util.FindWorkAndModFiles(destDir)
 		if err != nil {
 			return err
 		}
-
-		modFile, err := modfile.Parse("go.mod", data, nil)
-		if err != nil {
+		if err := RewriteGoModFiles(originalRoot, copiedRoot, modFiles, externalBase, externals); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		changed := false
-		for _, rep := range modFile.Replace {
-			if rep.New.Version != "" || rep.New.Path == "" {
-				continue
-			}
-			origNewAbs := rep.New.Path
-			if !filepath.IsAbs(origNewAbs) {
-				origNewAbs = filepath.Clean(filepath.Join(modDirOriginal, rep.New.Path))
-			}
+// rewriteGoModFile points the path-based replaces of one copied go.mod at
+// the copied targets, editing the replace lines in place so comments and
+// layout are kept.
+func rewriteGoModFile(originalRoot, copiedRoot string, modPathCopied string, externalBase string, externals *ExternalCopies) error {
+	modDirCopied := filepath.Dir(modPathCopied)
+	modDirOriginal, err := externals.originalDir(modDirCopied, originalRoot, copiedRoot)
+	if err != nil {
+		return err
+	}
 
-			var targetAbs string
-			if util.IsWithin(origNewAbs, originalRoot) {
-				relFromOriginalRoot, err := filepath.Rel(originalRoot, origNewAbs)
-				if err != nil {
-					return err
-				}
-				targetAbs = filepath.Join(copiedRoot, relFromOriginalRoot)
-				fmt.Printf("[mod ] %s: replace %q => %q (inside source tree)\n", modPathCopied, rep.New.Path, targetAbs)
-			} else {
-				base := filepath.Base(origNewAbs)
-				destDir := util.UniqueDir(filepath.Join(externalBase, base))
-				fmt.Printf("[mod ] %s: replace %q external -> copying to %s\n", modPathCopied, rep.New.Path, destDir)
-				if err := copytree.CopyTreeNormalized(origNewAbs, destDir); err != nil {
-					return err
-				}
-				targetAbs = destDir
-			}
+	data, err := os.ReadFile(modPathCopied)
+	if err != nil {
+		return err
+	}
 
-			relFromModToTarget, err := filepath.Rel(modDirCopied, targetAbs)
-			if err != nil {
-				return err
-			}
-			finalRel := filepath.ToSlash(relFromModToTarget)
-			fmt.Printf("[mod ] %s: replace %q => %q (final path in go.mod)\n", modPathCopied, rep.New.Path, finalRel)
+	modFile, err := modfile.Parse(modPathCopied, data, nil)
+	if err != nil {
+		return err
+	}
 
-			rep.New.Path = finalRel
-			rep.New.Version = ""
-			changed = true
+	changed := false
+	for _, rep := range modFile.Replace {
+		if rep.New.Version != "" || rep.New.Path == "" {
+			continue
+		}
+		origNewAbs := rep.New.Path
+		if !filepath.IsAbs(origNewAbs) {
+			origNewAbs = filepath.Clean(filepath.Join(modDirOriginal, rep.New.Path))
 		}
 
-		if changed {
-			formatted, err := modFile.Format()
+		var targetAbs string
+		if util.IsWithin(origNewAbs, originalRoot) {
+			relFromOriginalRoot, err := filepath.Rel(originalRoot, origNewAbs)
 			if err != nil {
 				return err
 			}
-			if err := os.WriteFile(modPathCopied, formatted, 0o644); err != nil {
+			targetAbs = filepath.Join(copiedRoot, relFromOriginalRoot)
+			fmt.Printf("[mod ] %s: replace %q => %q (inside source tree)\n", modPathCopied, rep.New.Path, targetAbs)
+		} else {
+			destDir, fresh, err := externals.copyExternal(origNewAbs, externalBase)
+			if errors.Is(err, errNotDir) {
+				fmt.Printf("[mod ] %s: replace %q external -> does not exist, left as is\n", modPathCopied, rep.New.Path)
+				continue
+			}
+			if err != nil {
 				return err
 			}
+			fmt.Printf("[mod ] %s: replace %q external -> %s %s\n", modPathCopied, rep.New.Path, copiedOrReused(fresh), destDir)
+			targetAbs = destDir
 		}
-	}
-	return nil
-}
 
-// renderGoWorkInPlace replaces all existing USE and path-based REPLACE entries
-// on the provided WorkFile using public helpers, then returns modfile.Format.
-// It panics on any helper error.
-func renderGoWorkInPlace(wf *modfile.WorkFile, desiredUsePaths []string, desiredReplaces []ReplaceEdit) []byte {
-	// Normalize, dedupe, sort uses deterministically
-	seen := make(map[string]struct{}, len(desiredUsePaths))
-	cleanUses := make([]string, 0, len(desiredUsePaths))
-	for _, p := range desiredUsePaths {
-		p = filepath.ToSlash(strings.TrimSpace(p))
-		if p == "" {
-			continue
+		relFromModToTarget, err := filepath.Rel(modDirCopied, targetAbs)
+		if err != nil {
+			return err
 		}
-		if _, ok := seen[p]; !ok {
-			seen[p] = struct{}{}
-			cleanUses = append(cleanUses, p)
+		finalRel := dirPath(relFromModToTarget)
+		if finalRel == rep.New.Path {
+			continue
 		}
-	}
-	sort.Strings(cleanUses)
+		fmt.Printf("[mod ] %s: replace %q => %q (final path in go.mod)\n", modPathCopied, rep.New.Path, finalRel)
 
-	// Collect original tokens BEFORE any edits
-	var origUses []string
-	for _, u := range wf.Use {
-		origUses = append(origUses, filepath.ToSlash(strings.TrimSpace(u.Path)))
-	}
-	type orep struct{ oldPath, oldVersion string }
-	var origRepls []orep
-	for _, r := range wf.Replace {
-		origRepls = append(origRepls, orep{oldPath: r.Old.Path, oldVersion: r.Old.Version})
+		// Format prints the syntax tree only, so setting rep.New alone would
+		// leave the file unchanged
+		setLastToken(rep.Syntax, finalRel)
+		rep.New.Path = finalRel
+		changed = true
 	}
 
-	// Drop all original uses
-	for _, up := range origUses {
-		if err := wf.DropUse(up); err != nil {
-			panic(err)
+	if changed {
+		formatted, err := modFile.Format()
+		if err != nil {
+			return err
 		}
-	}
-	// Drop all original replaces
-	for _, or := range origRepls {
-		if err := wf.DropReplace(or.oldPath, or.oldVersion); err != nil {
-			panic(err)
+		if err := os.WriteFile(modPathCopied, formatted, 0o644); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// Add desired uses
-	for _, p := range cleanUses {
-		if err := wf.AddUse(p, p); err != nil {
-			panic(err)
-		}
+// setLastToken replaces the path at the end of a use or directory replace
+// line, leaving the rest of the line and its comments as they are.
+func setLastToken(line *modfile.Line, p string) {
+	line.Token[len(line.Token)-1] = modfile.AutoQuote(p)
+}
+
+// resolvesTo reports whether the directive path p, relative to dir, already
+// names target.
+func resolvesTo(dir string, p string, target string) bool {
+	if filepath.IsAbs(p) {
+		return false // an absolute path names the original, not the copy
 	}
+	return filepath.Join(dir, filepath.FromSlash(p)) == filepath.Clean(target)
+}
 
-	// Add desired replaces
-	for _, nr := range desiredReplaces {
-		if err := wf.AddReplace(nr.oldPath, nr.oldVersion, nr.newPath, nr.newVersion); err != nil {
-			panic(err)
-		}
+// dirPath turns a relative path into the form replace directives need for a
+// directory: slash separated and starting with ./ or ../, since a bare path
+// would be read as a module path ("replace a => lib" does not parse).
+// filepath.Rel returns "lib" for a subdirectory, so every path computed for a
+// use or replace goes through here.
+func dirPath(rel string) string {
+	rel = filepath.ToSlash(rel)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return rel
 	}
+	return "./" + rel
+}
 
-	// Serialize updated syntax tree
-	return modfile.Format(wf.Syntax)
+func copiedOrReused(fresh bool) string {
+	if fresh {
+		return "copied to"
+	}
+	return "reusing copy"
 }
@@ -0,0 +1,94 @@
+package zipper
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// Format identifies an archive container format.
+type Format string
+
+const (
+	FormatZip    Format = "zip"
+	FormatTarGz  Format = "tar.gz"
+	FormatTarZst Format = "tar.zst"
+)
+
+// Formats lists the supported formats in their preferred order.
+var Formats = []Format{FormatZip, FormatTarGz, FormatTarZst}
+
+// Ext returns the file extension for f, including the leading dot.
+func (f Format) Ext() string { return "." + string(f) }
+
+// ParseFormat parses a format name as accepted on the command line.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(s, ".")) {
+	case "zip":
+		return FormatZip, nil
+	case "tar.gz", "tgz":
+		return FormatTarGz, nil
+	case "tar.zst", "tzst":
+		return FormatTarZst, nil
+	}
+	return "", fmt.Errorf("unknown archive format %q (want one of zip, tar.gz, tar.zst)", s)
+}
+
+// extensions maps every accepted archive extension to its format.
+var extensions = []struct {
+	ext    string
+	format Format
+}{
+	{".zip", FormatZip},
+	{".tar.gz", FormatTarGz},
+	{".tgz", FormatTarGz},
+	{".tar.zst", FormatTarZst},
+	{".tzst", FormatTarZst},
+}
+
+// FormatFromName derives the format from the extension of an output file name.
+func FormatFromName(name string) (Format, bool) {
+	lower := strings.ToLower(name)
+	for _, e := range extensions {
+		if strings.HasSuffix(lower, e.ext) {
+			return e.format, true
+		}
+	}
+	return "", false
+}
+
+// TrimExt returns name without its archive extension, if it has one of any
+// format, so "out/project.tgz" gives "out/project".
+func TrimExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, e := range extensions {
+		if strings.HasSuffix(lower, e.ext) {
+			return name[:len(name)-len(e.ext)]
+		}
+	}
+	return name
+}
+
+// Writer receives archive entries in walk order. Names use forward slashes
+// and never carry a trailing slash; implementations add one where needed.
+type Writer interface {
+	WriteDir(name string, info fs.FileInfo) error
+	WriteFile(name string, info fs.FileInfo, r io.Reader) error
+	WriteSymlink(name string, info fs.FileInfo, target string) error
+	Close() error
+}
+
+// NewWriter returns an archive Writer for format f writing to w.
+// Closing the Writer flushes the archive but does not close w.
+func NewWriter(f Format, w io.Writer) (Writer, error) {
+	switch f {
+	case FormatZip:
+		return newZipWriter(w), nil
+	case FormatTarGz:
+		return newTarGzWriter(w)
+	case FormatTarZst:
+		return newTarZstWriter(w)
+	}
+	return nil, fmt.Errorf("unsupported archive format %q", f)
+}
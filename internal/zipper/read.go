@@ -0,0 +1,98 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// WalkArchive calls fn for every file and symlink in the archive at path,
+// in stored order. The format is taken from the file name. Symlinks are
+// presented with their target as content, matching how zip stores them.
+func WalkArchive(path string, fn func(name string, r io.Reader) error) error {
+	format, ok := FormatFromName(path)
+	if !ok {
+		return fmt.Errorf("cannot infer archive format from %q", path)
+	}
+	if format == FormatZip {
+		return walkZip(path, fn)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader
+	switch format {
+	case FormatTarGz:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case FormatTarZst:
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	return walkTar(tar.NewReader(r), fn)
+}
+
+func walkZip(path string, fn func(name string, r io.Reader) error) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		if strings.HasSuffix(zf.Name, "/") {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(zf.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(tr *tar.Reader, fn func(name string, r io.Reader) error) error {
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch h.Typeflag {
+		case tar.TypeReg:
+			err = fn(h.Name, tr)
+		case tar.TypeSymlink:
+			err = fn(h.Name, strings.NewReader(h.Linkname))
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
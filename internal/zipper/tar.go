@@ -0,0 +1,70 @@
+package zipper
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/fs"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// tarWriter writes a tar stream through a compressor. Symlinks and modes are
+// kept as native tar headers.
+type tarWriter struct {
+	tw   *tar.Writer
+	comp io.WriteCloser
+}
+
+func newTarGzWriter(w io.Writer) (*tarWriter, error) {
+	gz := gzip.NewWriter(w)
+	return &tarWriter{tw: tar.NewWriter(gz), comp: gz}, nil
+}
+
+func newTarZstWriter(w io.Writer) (*tarWriter, error) {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	return &tarWriter{tw: tar.NewWriter(zw), comp: zw}, nil
+}
+
+func (t *tarWriter) WriteDir(name string, info fs.FileInfo) error {
+	h, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	h.Name = name + "/"
+	return t.tw.WriteHeader(h)
+}
+
+func (t *tarWriter) WriteFile(name string, info fs.FileInfo, r io.Reader) error {
+	h, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	h.Name = name
+	if err := t.tw.WriteHeader(h); err != nil {
+		return err
+	}
+	_, err = io.Copy(t.tw, r)
+	return err
+}
+
+func (t *tarWriter) WriteSymlink(name string, info fs.FileInfo, target string) error {
+	h, err := tar.FileInfoHeader(info, target)
+	if err != nil {
+		return err
+	}
+	h.Name = name
+	return t.tw.WriteHeader(h)
+}
+
+func (t *tarWriter) Close() error {
+	tarErr := t.tw.Close()
+	compErr := t.comp.Close()
+	if tarErr != nil {
+		return tarErr
+	}
+	return compErr
+}
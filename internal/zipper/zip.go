@@ -0,0 +1,60 @@
+package zipper
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"os"
+)
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func newZipWriter(w io.Writer) *zipWriter {
+	return &zipWriter{zw: zip.NewWriter(w)}
+}
+
+func (z *zipWriter) WriteDir(name string, info fs.FileInfo) error {
+	h, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	h.Name = name + "/"
+	_, err = z.zw.CreateHeader(h)
+	return err
+}
+
+func (z *zipWriter) WriteFile(name string, info fs.FileInfo, r io.Reader) error {
+	h, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	h.Name = name
+	h.Method = zip.Deflate
+
+	w, err := z.zw.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// WriteSymlink stores the link target as file content, the way Info-ZIP does.
+func (z *zipWriter) WriteSymlink(name string, info fs.FileInfo, target string) error {
+	h, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	h.Name = name
+	h.SetMode(os.ModeSymlink | 0o777)
+	w, err := z.zw.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, target)
+	return err
+}
+
+func (z *zipWriter) Close() error { return z.zw.Close() }
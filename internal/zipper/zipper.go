@@ -1,42 +1,64 @@
 package zipper
 
 import (
-	"archive/zip"
-	"io"
+	"bytes"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+// ExtraFile is a generated file added under the archive root after the walk.
+type ExtraFile struct {
+	Name string
+	Data []byte
+}
+
+// Allow reports whether a file is packaged. relName is relative to the
+// package root.
+// Only includes *.go, *.gotmpl, go.mod, go.sum, modules.txt, go.work and
+// the _external/mapping.json written by workedit at the root.
+func Allow(relName string) bool {
+	base := filepath.Base(relName)
+	switch base {
+	case "go.mod", "go.sum", "modules.txt", "go.work":
+		return true
+	case "mapping.json":
+		return filepath.ToSlash(relName) == "_external/mapping.json"
+	}
+	ext := strings.ToLower(filepath.Ext(base))
+	return ext == ".go" || ext == ".gotmpl"
+}
+
 // ZipDirFilteredIncludeRoot zips srcDir as a top-level folder into destZip.
-// Only includes *.go, *.gotmpl, go.mod, go.sum, modules.txt, go.work.
-// Symlinks are stored with their target as file content.
+// See ArchiveDirFilteredIncludeRoot for the filter.
 func ZipDirFilteredIncludeRoot(srcDir string, destZip string) error {
-	allow := func(relName string) bool {
-		base := filepath.Base(relName)
-		switch base {
-		case "go.mod", "go.sum", "modules.txt", "go.work":
-			return true
-		}
-		ext := strings.ToLower(filepath.Ext(base))
-		return ext == ".go" || ext == ".gotmpl"
-	}
+	return ArchiveDirFilteredIncludeRoot(srcDir, destZip, FormatZip)
+}
 
-	if err := os.MkdirAll(filepath.Dir(destZip), 0o755); err != nil {
+// ArchiveDirFilteredIncludeRoot archives srcDir as a top-level folder into
+// destPath using the given format. Only files accepted by Allow are included,
+// followed by any extra files.
+// Symlinks are stored as the format allows: zip keeps the target as file
+// content, tar keeps a native symlink entry.
+func ArchiveDirFilteredIncludeRoot(srcDir string, destPath string, format Format, extra ...ExtraFile) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
 		return err
 	}
-	zipFile, err := os.Create(destZip)
+	outFile, err := os.Create(destPath)
 	if err != nil {
 		return err
 	}
-	defer zipFile.Close()
+	defer outFile.Close()
 
-	zw := zip.NewWriter(zipFile)
-	defer zw.Close()
+	aw, err := NewWriter(format, outFile)
+	if err != nil {
+		return err
+	}
 
 	parent := filepath.Dir(srcDir)
-	return filepath.WalkDir(parent, func(currentPath string, entry fs.DirEntry, walkErr error) error {
+	walkErr := filepath.WalkDir(parent, func(currentPath string, entry fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			return walkErr
 		}
@@ -55,16 +77,14 @@ func ZipDirFilteredIncludeRoot(srcDir string, destZip string) error {
 		}
 
 		if entry.IsDir() {
-			h, err := zip.FileInfoHeader(info)
-			if err != nil {
-				return err
-			}
-			h.Name = name + "/"
-			_, err = zw.CreateHeader(h)
-			return err
+			return aw.WriteDir(name, info)
 		}
 
-		if !allow(name) {
+		relFromSrc, err := filepath.Rel(srcDir, currentPath)
+		if err != nil {
+			return err
+		}
+		if !Allow(relFromSrc) {
 			return nil
 		}
 
@@ -73,37 +93,39 @@ func ZipDirFilteredIncludeRoot(srcDir string, destZip string) error {
 			if err != nil {
 				return err
 			}
-			h, err := zip.FileInfoHeader(info)
-			if err != nil {
-				return err
-			}
-			h.Name = name
-			h.SetMode(os.ModeSymlink | 0o777)
-			w, err := zw.CreateHeader(h)
-			if err != nil {
-				return err
-			}
-			_, err = io.WriteString(w, target)
-			return err
-		}
-
-		h, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return err
+			return aw.WriteSymlink(name, info, target)
 		}
-		h.Name = name
-		h.Method = zip.Deflate
 
-		w, err := zw.CreateHeader(h)
-		if err != nil {
-			return err
-		}
 		f, err := os.Open(currentPath)
 		if err != nil {
 			return err
 		}
 		defer f.Close()
-		_, err = io.Copy(w, f)
-		return err
+		return aw.WriteFile(name, info, f)
 	})
+	if walkErr != nil {
+		_ = aw.Close()
+		return walkErr
+	}
+	for _, ef := range extra {
+		name := filepath.ToSlash(filepath.Join(filepath.Base(srcDir), ef.Name))
+		if err := aw.WriteFile(name, extraFileInfo{ef}, bytes.NewReader(ef.Data)); err != nil {
+			_ = aw.Close()
+			return err
+		}
+	}
+	if err := aw.Close(); err != nil {
+		return err
+	}
+	return outFile.Close()
 }
+
+// extraFileInfo describes an ExtraFile as a regular 0644 file.
+type extraFileInfo struct{ ef ExtraFile }
+
+func (fi extraFileInfo) Name() string       { return filepath.Base(fi.ef.Name) }
+func (fi extraFileInfo) Size() int64        { return int64(len(fi.ef.Data)) }
+func (fi extraFileInfo) Mode() fs.FileMode  { return 0o644 }
+func (fi extraFileInfo) ModTime() time.Time { return time.Now() }
+func (fi extraFileInfo) IsDir() bool        { return false }
+func (fi extraFileInfo) Sys() any           { return nil }